@@ -0,0 +1,458 @@
+package main
+
+import (
+	"EBG.IssataySheg.net/internal/data"
+	"EBG.IssataySheg.net/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Search string
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Search = app.readString(qs, "search", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	users, metadata, err := app.models.Users.GetAll(input.Search, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+	app.writeUserPermissions(w, r, user)
+}
+
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Codes []string `json:"codes"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(len(input.Codes) > 0, "codes", "must contain at least 1 permission code")
+	v.Check(validator.Unique(input.Codes), "codes", "must not contain duplicate values")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.checkPermissionCodes(v, input.Codes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Permissions.AddForUser(user.ID, input.Codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.auth.invalidateUser(user.ID)
+	app.writeUserPermissions(w, r, user)
+}
+
+func (app *application) assignUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(len(input.Roles) > 0, "roles", "must contain at least 1 role")
+	v.Check(validator.Unique(input.Roles), "roles", "must not contain duplicate values")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	known := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		known[role.Code] = true
+	}
+	for _, code := range input.Roles {
+		v.Check(known[code], "roles", fmt.Sprintf("unknown role %q", code))
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Roles.AddForUser(user.ID, input.Roles...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.auth.invalidateUser(user.ID)
+	app.writeUserPermissions(w, r, user)
+}
+
+// removeUserRoleHandler takes a role away from a user. Like
+// revokeUserPermissionHandler, it refuses to take away the caller's own
+// users:admin.
+func (app *application) removeUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+	code := app.readStringParam(r, "role")
+	if user.ID == app.contextGetUser(r).ID {
+		keeps, err := app.keepsAdmin(user.ID, adminChange{removeRole: code})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !keeps {
+			v := validator.New()
+			v.AddError("role", "you cannot remove the role your own admin permission comes from")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+	err := app.models.Roles.RemoveForUser(user.ID, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.auth.invalidateUser(user.ID)
+	app.writeUserPermissions(w, r, user)
+}
+
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateRolePermissionsHandler replaces the permission codes bundled in a
+// role. Since that changes the permissions of every user with the role, the
+// whole authentication cache is flushed. Taking users:admin out of a role the
+// caller relies on for it is refused.
+func (app *application) updateRolePermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	role, err := app.models.Roles.Get(app.readStringParam(r, "code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var input struct {
+		Codes []string `json:"codes"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.Codes != nil, "codes", "must be provided")
+	v.Check(validator.Unique(input.Codes), "codes", "must not contain duplicate values")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.checkPermissionCodes(v, input.Codes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	role.Permissions = input.Codes
+	keeps, err := app.keepsAdmin(app.contextGetUser(r).ID, adminChange{role: role})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !keeps {
+		v.AddError("codes", "you cannot remove users:admin from the role your own admin permission comes from")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Roles.SetPermissions(role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.auth.flush()
+	err = app.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeUserPermissionHandler removes a permission code from a user. Admins
+// cannot revoke users:admin from themselves unless one of their roles also
+// grants it, so that the last admin cannot lock everybody out by accident.
+func (app *application) revokeUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+	code := app.readStringParam(r, "code")
+	if code == "users:admin" && user.ID == app.contextGetUser(r).ID {
+		keeps, err := app.keepsAdmin(user.ID, adminChange{revoke: code})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !keeps {
+			v := validator.New()
+			v.AddError("code", "you cannot revoke your own admin permission")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+	err := app.models.Permissions.RemoveForUser(user.ID, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.auth.invalidateUser(user.ID)
+	app.writeUserPermissions(w, r, user)
+}
+
+// adminChange describes a change to the permissions an admin holds: a
+// permission code revoked from them directly, a role taken away from them, or
+// new permissions for a role.
+type adminChange struct {
+	revoke     string
+	removeRole string
+	role       *data.Role
+}
+
+// keepsAdmin reports whether the user would still hold users:admin, directly
+// or through a role, once the change is made.
+func (app *application) keepsAdmin(userID int64, change adminChange) (bool, error) {
+	direct, err := app.models.Permissions.GetDirectForUser(userID)
+	if err != nil {
+		return false, err
+	}
+	if direct.Include("users:admin") && change.revoke != "users:admin" {
+		return true, nil
+	}
+	codes, err := app.models.Roles.GetAllForUser(userID)
+	if err != nil {
+		return false, err
+	}
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		return false, err
+	}
+	held := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		held[code] = code != change.removeRole
+	}
+	for _, role := range roles {
+		if !held[role.Code] {
+			continue
+		}
+		permissions := role.Permissions
+		if change.role != nil && change.role.Code == role.Code {
+			permissions = change.role.Permissions
+		}
+		if permissions.Include("users:admin") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// unlockUserHandler lifts a lockout caused by failed sign-ins and forgets the
+// failures.
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+	err := app.models.Throttles.Reset(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account successfully unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	permission := &data.Permission{Code: input.Code}
+	v := validator.New()
+	if data.ValidatePermissionCode(v, permission.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Permissions.Insert(permission)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicatePermission):
+			v.AddError("code", "a permission with this code already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"permission": permission}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) readUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+// writeUserPermissions responds with the user's roles and direct permission
+// grants, along with the effective permissions they add up to.
+func (app *application) writeUserPermissions(w http.ResponseWriter, r *http.Request, user *data.User) {
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	direct, err := app.models.Permissions.GetDirectForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	effective, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelope{
+		"user":                  user,
+		"roles":                 roles,
+		"permissions":           direct,
+		"effective_permissions": effective,
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkPermissionCodes adds a validation error for each code which is not a
+// known permission.
+func (app *application) checkPermissionCodes(v *validator.Validator, codes []string) error {
+	permissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(permissions))
+	for _, permission := range permissions {
+		known[permission.Code] = true
+	}
+	for _, code := range codes {
+		v.Check(known[code], "codes", fmt.Sprintf("unknown permission code %q", code))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"EBG.IssataySheg.net/internal/data"
+	"EBG.IssataySheg.net/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// createAPIKeyHandler issues a named API key limited to the given permission
+// codes, each of which the user must currently hold, other than users:admin,
+// which is never granted. The key itself is only ever shown in this response.
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name   string     `json:"name"`
+		Scopes []string   `json:"scopes"`
+		Expiry *time.Time `json:"expiry"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	key := &data.APIKey{
+		UserID: user.ID,
+		Name:   input.Name,
+		Scopes: input.Scopes,
+		Expiry: input.Expiry,
+	}
+	v := validator.New()
+	if data.ValidateAPIKey(v, key); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	permissions, err := app.requestPermissions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, code := range key.Scopes {
+		v.Check(permissions.Include(code), "scopes", fmt.Sprintf("you do not have the %q permission", code))
+		v.Check(code != "users:admin", "scopes", "users:admin cannot be granted to API keys")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	key, err = app.models.APIKeys.New(key.UserID, key.Name, key.Scopes, key.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateAPIKey):
+			v.AddError("name", "you already have an API key with this name")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.models.APIKeys.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	user := app.contextGetUser(r)
+	err = app.models.APIKeys.Delete(user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.auth.invalidateUser(user.ID)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "API key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
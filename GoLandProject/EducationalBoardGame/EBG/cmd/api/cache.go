@@ -0,0 +1,245 @@
+package main
+
+import (
+	"EBG.IssataySheg.net/internal/data"
+	"expvar"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	authCacheHits   = expvar.NewInt("auth_cache_hits")
+	authCacheMisses = expvar.NewInt("auth_cache_misses")
+)
+
+// authCacheMetricsHandler reports the authentication cache counters. It
+// serves them itself rather than through expvar.Handler, which would also
+// publish the command line and with it the secrets passed as flags.
+func (app *application) authCacheMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	hits, misses := authCacheHits.Value(), authCacheMisses.Value()
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+	env := envelope{
+		"auth_cache_hits":     hits,
+		"auth_cache_misses":   misses,
+		"auth_cache_hit_rate": hitRate,
+	}
+	err := app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// authCache holds the user behind each recently used authentication token,
+// along with their effective permissions, so that authenticate and
+// requirePermission do not query the database on every request. Entries are
+// keyed by token hash and live for at most the configured TTL; a token which
+// expires naturally may therefore keep working for up to one TTL longer.
+// Anything which changes a user's tokens, activation or permissions must call
+// invalidateUser, and changes to role permissions must call flush.
+//
+// A request which misses the cache reads the database and then fills the
+// cache, and an invalidation can land in between. So that the stale result is
+// not cached, every invalidation bumps a generation counter; callers read it
+// with generation before going to the database, and setUser and
+// setPermissions drop the result if the user has been invalidated since.
+type authCache struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	entries     map[string]*authCacheEntry
+	byUser      map[int64]map[string]bool
+	gen         uint64
+	invalidated map[int64]authCacheInvalidation
+	// flushed is the generation of the last flush, or of the newest
+	// invalidation dropped from invalidated. Results read before it are not
+	// cached, since there is no telling which users have been invalidated.
+	flushed uint64
+}
+
+// authCacheInvalidation records the generation at which a user was last
+// invalidated, and when, so that old records can be dropped.
+type authCacheInvalidation struct {
+	gen uint64
+	at  time.Time
+}
+
+// delegation is a credential with which a script or third-party app acts for
+// a user with only some of their permissions: an API key or an OAuth2 access
+// token.
+type delegation interface {
+	Permissions(owner data.Permissions) data.Permissions
+}
+
+type authCacheEntry struct {
+	user        *data.User
+	delegation  delegation
+	permissions data.Permissions
+	expires     time.Time
+}
+
+func newAuthCache(ttl time.Duration) *authCache {
+	c := &authCache{
+		ttl:         ttl,
+		entries:     make(map[string]*authCacheEntry),
+		byUser:      make(map[int64]map[string]bool),
+		invalidated: make(map[int64]authCacheInvalidation),
+	}
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			c.mu.Lock()
+			for key, entry := range c.entries {
+				if time.Now().After(entry.expires) {
+					c.remove(key)
+				}
+			}
+			for userID, inv := range c.invalidated {
+				if time.Since(inv.at) > time.Minute {
+					delete(c.invalidated, userID)
+					if inv.gen > c.flushed {
+						c.flushed = inv.gen
+					}
+				}
+			}
+			c.mu.Unlock()
+		}
+	}()
+	return c
+}
+
+// lookup returns the live entry for the key. The caller must hold c.mu.
+func (c *authCache) lookup(key string) (*authCacheEntry, bool) {
+	entry, found := c.entries[key]
+	if !found || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// getUser returns a copy of the cached user, so that handlers are free to
+// modify it, along with the delegation if the credential is one. Every
+// authenticated request calls it once, so it alone counts hits and misses.
+func (c *authCache) getUser(key string) (*data.User, delegation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, found := c.lookup(key)
+	if !found {
+		authCacheMisses.Add(1)
+		return nil, nil, false
+	}
+	authCacheHits.Add(1)
+	user := *entry.user
+	return &user, entry.delegation, true
+}
+
+// generation returns the current generation, to be read before looking up a
+// user or their permissions in the database and passed to setUser or
+// setPermissions afterwards.
+func (c *authCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// stale reports whether a result for the user read from the database at
+// generation gen may have been invalidated since. The caller must hold c.mu.
+func (c *authCache) stale(userID int64, gen uint64) bool {
+	return gen < c.flushed || c.invalidated[userID].gen > gen
+}
+
+// setUser caches the user behind the key, read from the database at
+// generation gen. It does nothing if the user has been invalidated since.
+func (c *authCache) setUser(key string, user *data.User, d delegation, gen uint64) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stale(user.ID, gen) {
+		return
+	}
+	cached := *user
+	c.remove(key)
+	c.entries[key] = &authCacheEntry{user: &cached, delegation: d, expires: time.Now().Add(c.ttl)}
+	if c.byUser[user.ID] == nil {
+		c.byUser[user.ID] = make(map[string]bool)
+	}
+	c.byUser[user.ID][key] = true
+}
+
+func (c *authCache) getPermissions(key string) (data.Permissions, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, found := c.lookup(key)
+	if !found || entry.permissions == nil {
+		return nil, false
+	}
+	return entry.permissions, true
+}
+
+// setPermissions caches the permissions, read from the database at generation
+// gen, alongside the user already cached for the key. It does nothing if the
+// entry or the user has been invalidated in the meantime.
+func (c *authCache) setPermissions(key string, permissions data.Permissions, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, found := c.entries[key]; found && !c.stale(entry.user.ID, gen) {
+		entry.permissions = permissions
+	}
+}
+
+func (c *authCache) invalidateUser(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.invalidated[userID] = authCacheInvalidation{gen: c.gen, at: time.Now()}
+	for key := range c.byUser[userID] {
+		c.remove(key)
+	}
+}
+
+func (c *authCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.flushed = c.gen
+	c.entries = make(map[string]*authCacheEntry)
+	c.byUser = make(map[int64]map[string]bool)
+	c.invalidated = make(map[int64]authCacheInvalidation)
+}
+
+// remove deletes the entry for the key. The caller must hold c.mu.
+func (c *authCache) remove(key string) {
+	entry, found := c.entries[key]
+	if !found {
+		return
+	}
+	delete(c.entries, key)
+	delete(c.byUser[entry.user.ID], key)
+	if len(c.byUser[entry.user.ID]) == 0 {
+		delete(c.byUser, entry.user.ID)
+	}
+}
+
+// requestPermissions returns the effective permissions of the user making the
+// request, from the cache if possible. Requests made with an API key only get
+// the permissions within its scopes.
+func (app *application) requestPermissions(r *http.Request) (data.Permissions, error) {
+	key := string(data.HashToken(app.contextGetToken(r)))
+	if permissions, found := app.auth.getPermissions(key); found {
+		return permissions, nil
+	}
+	gen := app.auth.generation()
+	permissions, err := app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		return nil, err
+	}
+	if d := app.contextGetDelegation(r); d != nil {
+		permissions = d.Permissions(permissions)
+	}
+	app.auth.setPermissions(key, permissions, gen)
+	return permissions, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"EBG.IssataySheg.net/internal/data"
+	"testing"
+	"time"
+)
+
+func TestAuthCacheDropsStaleResults(t *testing.T) {
+	alice, bob := &data.User{ID: 1}, &data.User{ID: 2}
+	tests := []struct {
+		name       string
+		invalidate func(c *authCache)
+		cached     bool
+	}{
+		{"no invalidation", func(c *authCache) {}, true},
+		{"user invalidated", func(c *authCache) { c.invalidateUser(alice.ID) }, false},
+		{"another user invalidated", func(c *authCache) { c.invalidateUser(bob.ID) }, true},
+		{"flushed", func(c *authCache) { c.flush() }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newAuthCache(time.Minute)
+			gen := c.generation()
+			tt.invalidate(c)
+			c.setUser("key", alice, nil, gen)
+			if _, _, found := c.getUser("key"); found != tt.cached {
+				t.Errorf("got user cached %t; want %t", found, tt.cached)
+			}
+		})
+	}
+}
+
+func TestAuthCacheDropsStalePermissions(t *testing.T) {
+	c := newAuthCache(time.Minute)
+	user := &data.User{ID: 1}
+	c.setUser("key", user, nil, c.generation())
+	gen := c.generation()
+	c.invalidateUser(user.ID)
+	c.setUser("key", user, nil, c.generation())
+	c.setPermissions("key", data.Permissions{"users:admin"}, gen)
+	if _, found := c.getPermissions("key"); found {
+		t.Error("permissions read before the invalidation were cached")
+	}
+	c.setPermissions("key", data.Permissions{"games:read"}, c.generation())
+	if permissions, found := c.getPermissions("key"); !found || !permissions.Include("games:read") {
+		t.Errorf("got permissions %v, found %t; want games:read", permissions, found)
+	}
+}
+
+func TestAuthCacheCountsOncePerRequest(t *testing.T) {
+	c := newAuthCache(time.Minute)
+	user := &data.User{ID: 1}
+	hits, misses := authCacheHits.Value(), authCacheMisses.Value()
+	c.getUser("key")
+	c.setUser("key", user, nil, c.generation())
+	c.getUser("key")
+	c.getPermissions("key")
+	c.setPermissions("key", data.Permissions{"games:read"}, c.generation())
+	c.getPermissions("key")
+	if got := authCacheHits.Value() - hits; got != 1 {
+		t.Errorf("got %d hits; want 1", got)
+	}
+	if got := authCacheMisses.Value() - misses; got != 1 {
+		t.Errorf("got %d misses; want 1", got)
+	}
+}
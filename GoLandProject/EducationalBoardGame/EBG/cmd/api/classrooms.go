@@ -0,0 +1,318 @@
+package main
+
+import (
+	"EBG.IssataySheg.net/internal/data"
+	"EBG.IssataySheg.net/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func (app *application) createClassroomHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	classroom := &data.Classroom{
+		TeacherID: app.contextGetUser(r).ID,
+		Name:      input.Name,
+	}
+	v := validator.New()
+	if data.ValidateClassroom(v, classroom); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Classrooms.Insert(classroom)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/classrooms/%d", classroom.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"classroom": classroom}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listClassroomsHandler returns the classrooms the user teaches alongside the
+// ones they have joined as a student. Join codes are only shown to teachers.
+func (app *application) listClassroomsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	teaching, err := app.models.Classrooms.GetAllForTeacher(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	enrolled, err := app.models.Classrooms.GetAllForStudent(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, classroom := range enrolled {
+		classroom.JoinCode = ""
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"teaching": teaching, "enrolled": enrolled}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showClassroomHandler(w http.ResponseWriter, r *http.Request) {
+	classroom, ok := app.readClassroom(w, r)
+	if !ok {
+		return
+	}
+	user := app.contextGetUser(r)
+	if classroom.TeacherID != user.ID {
+		enrolled, err := app.models.Classrooms.IsStudent(classroom.ID, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !enrolled {
+			app.notFoundResponse(w, r)
+			return
+		}
+		classroom.JoinCode = ""
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"classroom": classroom}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateClassroomHandler(w http.ResponseWriter, r *http.Request) {
+	classroom, ok := app.readTaughtClassroom(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Name *string `json:"name"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Name != nil {
+		classroom.Name = *input.Name
+	}
+	v := validator.New()
+	if data.ValidateClassroom(v, classroom); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Classrooms.Update(classroom)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"classroom": classroom}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteClassroomHandler(w http.ResponseWriter, r *http.Request) {
+	classroom, ok := app.readTaughtClassroom(w, r)
+	if !ok {
+		return
+	}
+	err := app.models.Classrooms.Delete(classroom.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "classroom successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// regenerateJoinCodeHandler issues a new join code for the classroom. Students
+// who have already joined stay enrolled, but the old code stops working.
+func (app *application) regenerateJoinCodeHandler(w http.ResponseWriter, r *http.Request) {
+	classroom, ok := app.readTaughtClassroom(w, r)
+	if !ok {
+		return
+	}
+	err := app.models.Classrooms.RegenerateJoinCode(classroom)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"classroom": classroom}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listClassroomStudentsHandler(w http.ResponseWriter, r *http.Request) {
+	classroom, ok := app.readTaughtClassroom(w, r)
+	if !ok {
+		return
+	}
+	students, err := app.models.Classrooms.GetStudents(classroom.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"classroom": classroom, "students": students}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeClassroomStudentHandler(w http.ResponseWriter, r *http.Request) {
+	classroom, ok := app.readTaughtClassroom(w, r)
+	if !ok {
+		return
+	}
+	userID, err := app.readNamedIDParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Classrooms.RemoveStudent(classroom.ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "student successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) joinClassroomHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	// Codes are generated in upper case, but people type them however they like.
+	input.Code = strings.ToUpper(strings.TrimSpace(input.Code))
+	v := validator.New()
+	if data.ValidateJoinCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	classroom, err := app.models.Classrooms.GetByJoinCode(input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "invalid join code")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	user := app.contextGetUser(r)
+	if classroom.TeacherID == user.ID {
+		v.AddError("code", "you cannot join a classroom you teach")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Classrooms.AddStudent(classroom.ID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateStudent):
+			v.AddError("code", "you have already joined this classroom")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	classroom.JoinCode = ""
+	classroom.StudentCount++
+	err = app.writeJSON(w, http.StatusCreated, envelope{"classroom": classroom}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) leaveClassroomHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Classrooms.RemoveStudent(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "successfully left classroom"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) readClassroom(w http.ResponseWriter, r *http.Request) (*data.Classroom, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	classroom, err := app.models.Classrooms.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return classroom, true
+}
+
+// readTaughtClassroom fetches the classroom named by the id URL parameter and
+// responds with 404 Not Found unless the user is its teacher.
+func (app *application) readTaughtClassroom(w http.ResponseWriter, r *http.Request) (*data.Classroom, bool) {
+	classroom, ok := app.readClassroom(w, r)
+	if !ok {
+		return nil, false
+	}
+	if classroom.TeacherID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	return classroom, true
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"EBG.IssataySheg.net/internal/data"
+	"EBG.IssataySheg.net/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func (app *application) createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Public      bool   `json:"public"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	collection := &data.Collection{
+		UserID:      app.contextGetUser(r).ID,
+		Name:        input.Name,
+		Description: input.Description,
+	}
+	v := validator.New()
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if input.Public {
+		if app.contextGetUser(r).NeedsConsent(app.config.consentAge) {
+			app.consentRequiredResponse(w, r)
+			return
+		}
+		err = collection.Share()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	err = app.models.Collections.Insert(collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCollection):
+			v.AddError("name", "you already have a collection with this name")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/collections/%d", collection.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"collection": collection}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	collections, err := app.models.Collections.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"collections": collections}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readOwnedCollection(w, r)
+	if !ok {
+		return
+	}
+	games, err := app.models.Collections.GetGames(collection.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection, "games": games}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showSharedCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	shareToken := app.readStringParam(r, "token")
+	collection, err := app.models.Collections.GetByShareToken(shareToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	games, err := app.models.Collections.GetGames(collection.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection, "games": games}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readOwnedCollection(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		Public      *bool   `json:"public"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Name != nil {
+		collection.Name = *input.Name
+	}
+	if input.Description != nil {
+		collection.Description = *input.Description
+	}
+	v := validator.New()
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if input.Public != nil {
+		if *input.Public {
+			if app.contextGetUser(r).NeedsConsent(app.config.consentAge) {
+				app.consentRequiredResponse(w, r)
+				return
+			}
+			err = collection.Share()
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		} else {
+			collection.Unshare()
+		}
+	}
+	err = app.models.Collections.Update(collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCollection):
+			v.AddError("name", "you already have a collection with this name")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readOwnedCollection(w, r)
+	if !ok {
+		return
+	}
+	err := app.models.Collections.Delete(collection.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "collection successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addCollectionGameHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readOwnedCollection(w, r)
+	if !ok {
+		return
+	}
+	gameID, err := app.readNamedIDParam(r, "game_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.models.Games.Get(gameID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.Collections.AddGame(collection.ID, gameID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.writeCollectionGames(w, r, collection)
+}
+
+func (app *application) removeCollectionGameHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readOwnedCollection(w, r)
+	if !ok {
+		return
+	}
+	gameID, err := app.readNamedIDParam(r, "game_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Collections.RemoveGame(collection.ID, gameID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.writeCollectionGames(w, r, collection)
+}
+
+func (app *application) reorderCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readOwnedCollection(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		GameIDs []int64 `json:"game_ids"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.GameIDs != nil, "game_ids", "must be provided")
+	v.Check(validator.Unique(input.GameIDs), "game_ids", "must not contain duplicate values")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Collections.Reorder(collection.ID, input.GameIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidOrder):
+			v.AddError("game_ids", "must list every game in the collection exactly once")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.writeCollectionGames(w, r, collection)
+}
+
+// readOwnedCollection fetches the collection named by the id URL parameter. It
+// responds with 404 Not Found, rather than 403 Forbidden, when the collection
+// belongs to somebody else so that other users' collections are not revealed.
+func (app *application) readOwnedCollection(w http.ResponseWriter, r *http.Request) (*data.Collection, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	collection, err := app.models.Collections.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	if collection.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	return collection, true
+}
+
+func (app *application) writeCollectionGames(w http.ResponseWriter, r *http.Request, collection *data.Collection) {
+	games, err := app.models.Collections.GetGames(collection.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	collection.GameCount = int32(len(games))
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection, "games": games}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
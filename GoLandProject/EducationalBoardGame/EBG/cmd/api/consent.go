@@ -0,0 +1,197 @@
+package main
+
+import (
+	"EBG.IssataySheg.net/internal/data"
+	"EBG.IssataySheg.net/internal/validator"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// requestConsent emails the user's guardian a token with which to consent to
+// the account, replacing any token sent before, and records the request in
+// the audit trail.
+func (app *application) requestConsent(r *http.Request, user *data.User) error {
+	err := app.models.Tokens.DeleteAllForUser(data.ScopeConsent, user.ID)
+	if err != nil {
+		return err
+	}
+	token, err := app.models.Tokens.New(user.ID, 7*24*time.Hour, data.ScopeConsent)
+	if err != nil {
+		return err
+	}
+	err = app.recordConsentEvent(r, user, data.ConsentRequested)
+	if err != nil {
+		return err
+	}
+	app.background(func() {
+		data := map[string]interface{}{
+			"consentToken": token.Plaintext,
+			"userName":     user.Name,
+			"consentAge":   app.config.consentAge,
+		}
+		err := app.mailer.Send(user.GuardianEmail, "guardian_consent.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+	return nil
+}
+
+func (app *application) recordConsentEvent(r *http.Request, user *data.User, event string) error {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return app.models.Consents.Insert(&data.ConsentEvent{
+		UserID:        user.ID,
+		Event:         event,
+		GuardianEmail: user.GuardianEmail,
+		IPAddress:     ip,
+		UserAgent:     r.UserAgent(),
+	})
+}
+
+// resendConsentHandler sends the consent email again, optionally to a
+// different guardian address.
+func (app *application) resendConsentHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !user.NeedsConsent(app.config.consentAge) {
+		app.consentNotRequiredResponse(w, r)
+		return
+	}
+	var input struct {
+		GuardianEmail *string `json:"guardian_email"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.GuardianEmail != nil && *input.GuardianEmail != user.GuardianEmail {
+		user.GuardianEmail = *input.GuardianEmail
+		v := validator.New()
+		data.ValidateUser(v, user)
+		data.ValidateGuardian(v, user, app.config.consentAge)
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		err = app.models.Users.Update(user)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		app.auth.invalidateUser(user.ID)
+	}
+	err = app.requestConsent(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelope{"message": "an email will be sent to your parent or guardian asking for their consent"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateConsentHandler records the guardian's answer to a consent request.
+// Either way the token is used up; after a refusal the account simply stays
+// restricted.
+func (app *application) updateConsentHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Consent        *bool  `json:"consent"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	v.Check(input.Consent != nil, "consent", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, err := app.models.Users.GetForToken(data.ScopeConsent, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired consent token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	event := data.ConsentDeclined
+	if *input.Consent {
+		event = data.ConsentGranted
+		now := time.Now()
+		user.ConsentedAt = &now
+		err = app.models.Users.Update(user)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+	err = app.recordConsentEvent(r, user, event)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeConsent, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.auth.invalidateUser(user.ID)
+	message := "thank you, the account remains restricted"
+	if *input.Consent {
+		message = "thank you, your consent has been recorded"
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listUserConsentEventsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+	events, err := app.models.Consents.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelope{"consent_required": user.NeedsConsent(app.config.consentAge), "events": events}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -8,7 +8,11 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey       = contextKey("user")
+	tokenContextKey      = contextKey("token")
+	delegationContextKey = contextKey("delegation")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -22,3 +26,27 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// contextSetToken stores the plaintext of the token the request was
+// authenticated with, so that handlers can act on the current session.
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenContextKey).(string)
+	return token
+}
+
+// contextSetDelegation records that the request was authenticated with an API
+// key or OAuth2 access token rather than an authentication token.
+func (app *application) contextSetDelegation(r *http.Request, d delegation) *http.Request {
+	ctx := context.WithValue(r.Context(), delegationContextKey, d)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetDelegation(r *http.Request) delegation {
+	d, _ := r.Context().Value(delegationContextKey).(delegation)
+	return d
+}
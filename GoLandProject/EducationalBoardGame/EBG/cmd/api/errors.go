@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -76,3 +79,45 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+func (app *application) totpEnabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is already enabled, disable it first to enrol a new device"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+func (app *application) delegationNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource cannot be accessed with an API key or OAuth token, please sign in"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+func (app *application) exportInProgressResponse(w http.ResponseWriter, r *http.Request) {
+	message := "an export is already being produced, please wait for it to finish"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+func (app *application) consentRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a parent or guardian must consent to your account before you can do this"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+func (app *application) consentNotRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your account does not need the consent of a parent or guardian"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+func (app *application) unverifiedEmailResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your identity provider has not verified your email address, so it cannot be used to sign in"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// oauthErrorResponse writes an error from the OAuth2 token endpoint in the
+// format RFC 6749 prescribes rather than our usual envelope.
+func (app *application) oauthErrorResponse(w http.ResponseWriter, r *http.Request, status int, code, description string) {
+	env := envelope{"error": code, "error_description": description}
+	headers := http.Header{"Cache-Control": {"no-store"}, "Pragma": {"no-cache"}}
+	err := app.writeJSON(w, status, env, headers)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+func (app *application) accountLockedResponse(w http.ResponseWriter, r *http.Request, lockedUntil time.Time) {
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	message := "too many failed sign-in attempts, this account is temporarily locked"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"EBG.IssataySheg.net/internal/data"
+	"EBG.IssataySheg.net/internal/validator"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// exportSection is one part of a personal data export. In a JSON export it is
+// a top-level key, in a ZIP export a file of its own.
+type exportSection struct {
+	name  string
+	value interface{}
+}
+
+func (app *application) createExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	app.startExport(w, r, user, user)
+}
+
+func (app *application) showExportHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeExport(w, r, app.contextGetUser(r))
+}
+
+func (app *application) downloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeExportArchive(w, r, app.contextGetUser(r))
+}
+
+func (app *application) createUserExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+	app.startExport(w, r, user, app.contextGetUser(r))
+}
+
+func (app *application) showUserExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+	app.writeExport(w, r, user)
+}
+
+func (app *application) downloadUserExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+	app.writeExportArchive(w, r, user)
+}
+
+// startExport records a new pending export of the user's data and produces it
+// in the background. The client polls the export until its status is ready
+// and then downloads it.
+func (app *application) startExport(w http.ResponseWriter, r *http.Request, user, requestedBy *data.User) {
+	format := app.readString(r.URL.Query(), "format", data.ExportFormatJSON)
+	v := validator.New()
+	if data.ValidateExportFormat(v, format); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	export, err := app.models.Exports.Start(user.ID, requestedBy.ID, format)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrExportInProgress):
+			app.exportInProgressResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.background(func() {
+		err := app.produceExport(user, format)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"user_id": fmt.Sprint(user.ID)})
+			err = app.models.Exports.Fail(user.ID)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+	})
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"export": export}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) produceExport(user *data.User, format string) error {
+	sections, err := app.collectExport(user.ID)
+	if err != nil {
+		return err
+	}
+	var archive []byte
+	switch format {
+	case data.ExportFormatZIP:
+		archive, err = encodeExportZIP(sections)
+	default:
+		archive, err = encodeExportJSON(sections)
+	}
+	if err != nil {
+		return err
+	}
+	return app.models.Exports.Complete(user.ID, archive, app.config.exportTTL)
+}
+
+// collectExport gathers everything held about the user. Secrets such as the
+// password hash, token and API key hashes and the TOTP secret are left out;
+// for tokens and API keys only their metadata is exported.
+func (app *application) collectExport(userID int64) ([]exportSection, error) {
+	user, err := app.models.Users.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := app.models.Roles.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := app.models.Tokens.GetAllForUser(userID, "")
+	if err != nil {
+		return nil, err
+	}
+	apiKeys, err := app.models.APIKeys.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	twoFactor := map[string]interface{}{"enabled": false}
+	totp, err := app.models.TOTP.Get(userID)
+	switch {
+	case err == nil:
+		twoFactor["enabled"] = totp.Confirmed
+		twoFactor["enrolled_at"] = totp.CreatedAt
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return nil, err
+	}
+	reviews, _, err := app.models.Reviews.GetAllForUser(userID, data.Filters{
+		Page:         1,
+		PageSize:     math.MaxInt32,
+		Sort:         "id",
+		SortSafelist: []string{"id"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	type collectionExport struct {
+		*data.Collection
+		Games []*data.Game `json:"games"`
+	}
+	collections, err := app.models.Collections.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	collectionExports := make([]collectionExport, len(collections))
+	for i, collection := range collections {
+		games, err := app.models.Collections.GetGames(collection.ID)
+		if err != nil {
+			return nil, err
+		}
+		collectionExports[i] = collectionExport{Collection: collection, Games: games}
+	}
+	sessions, _, err := app.models.Sessions.GetAllForUser(userID, data.Filters{
+		Page:         1,
+		PageSize:     math.MaxInt32,
+		Sort:         "id",
+		SortSafelist: []string{"id"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	teaching, err := app.models.Classrooms.GetAllForTeacher(userID)
+	if err != nil {
+		return nil, err
+	}
+	enrolled, err := app.models.Classrooms.GetAllForStudent(userID)
+	if err != nil {
+		return nil, err
+	}
+	// Join codes are only shown to teachers, as in listClassroomsHandler.
+	for _, classroom := range enrolled {
+		classroom.JoinCode = ""
+	}
+	authorizations, err := app.models.OAuthTokens.GetAuthorizations(userID)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := app.models.Identities.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	consentEvents, err := app.models.Consents.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return []exportSection{
+		{"user", user},
+		{"roles", roles},
+		{"permissions", permissions},
+		{"sign_ins", tokens},
+		{"api_keys", apiKeys},
+		{"identities", identities},
+		{"oauth_authorizations", authorizations},
+		{"two_factor", twoFactor},
+		{"consent", consentEvents},
+		{"reviews", reviews},
+		{"collections", collectionExports},
+		{"sessions", sessions},
+		{"classrooms", map[string]interface{}{"teaching": teaching, "enrolled": enrolled}},
+	}, nil
+}
+
+func encodeExportJSON(sections []exportSection) ([]byte, error) {
+	env := envelope{"exported_at": time.Now().UTC()}
+	for _, section := range sections {
+		env[section.name] = section.value
+	}
+	return json.MarshalIndent(env, "", "\t")
+}
+
+func encodeExportZIP(sections []exportSection) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	now := time.Now().UTC()
+	for _, section := range sections {
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     section.name + ".json",
+			Method:   zip.Deflate,
+			Modified: now,
+		})
+		if err != nil {
+			return nil, err
+		}
+		js, err := json.MarshalIndent(section.value, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+		_, err = f.Write(js)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := zw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (app *application) writeExport(w http.ResponseWriter, r *http.Request, user *data.User) {
+	export, err := app.models.Exports.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"export": export}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) writeExportArchive(w http.ResponseWriter, r *http.Request, user *data.User) {
+	export, archive, err := app.models.Exports.GetArchive(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	contentType := "application/json"
+	if export.Format == data.ExportFormatZIP {
+		contentType = "application/zip"
+	}
+	filename := fmt.Sprintf("ebg-export-%d-%s.%s", user.ID, export.CompletedAt.Format("20060102"), export.Format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Content-Length", fmt.Sprint(len(archive)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive)
+}
+
+// purgeExports deletes expired exports once an hour, for as long as the server
+// runs.
+func (app *application) purgeExports() {
+	for {
+		time.Sleep(time.Hour)
+		err := app.models.Exports.DeleteExpired()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}
+}
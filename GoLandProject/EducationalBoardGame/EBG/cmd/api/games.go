@@ -10,9 +10,19 @@ import (
 
 func (app *application) createGameHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title string     `json:"title"`
-		Score data.Score `json:"score"`
-		Games []string   `json:"games"`
+		Title       string     `json:"title"`
+		Description string     `json:"description"`
+		Score       data.Score `json:"score"`
+		Games       []string   `json:"games"`
+		MinPlayers  int32      `json:"min_players"`
+		MaxPlayers  int32      `json:"max_players"`
+		MinAge      int32      `json:"min_age"`
+		MaxAge      int32      `json:"max_age"`
+		PlayTime    int32      `json:"play_time"`
+		Subject     string     `json:"subject"`
+		Difficulty  string     `json:"difficulty"`
+		Publisher   string     `json:"publisher"`
+		Year        int32      `json:"year"`
 	}
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -20,16 +30,25 @@ func (app *application) createGameHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 	game := &data.Game{
-		Title: input.Title,
-		Score: input.Score,
-		Games: input.Games,
+		Title:       input.Title,
+		Description: input.Description,
+		Score:       input.Score,
+		Games:       input.Games,
+		MinPlayers:  input.MinPlayers,
+		MaxPlayers:  input.MaxPlayers,
+		MinAge:      input.MinAge,
+		MaxAge:      input.MaxAge,
+		PlayTime:    input.PlayTime,
+		Subject:     input.Subject,
+		Difficulty:  input.Difficulty,
+		Publisher:   input.Publisher,
+		Year:        input.Year,
 	}
 	v := validator.New()
-	if data.ValidateMovie(v, game); !v.Valid() {
+	if data.ValidateNewGame(v, game); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	fmt.Fprintf(w, "%+v\n", input)
 	err = app.models.Games.Insert(game)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -82,9 +101,19 @@ func (app *application) updateGameHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 	var input struct {
-		Title *string     `json:"title"`
-		Score *data.Score `json:"score"`
-		Games []string    `json:"games"`
+		Title       *string     `json:"title"`
+		Description *string     `json:"description"`
+		Score       *data.Score `json:"score"`
+		Games       []string    `json:"games"`
+		MinPlayers  *int32      `json:"min_players"`
+		MaxPlayers  *int32      `json:"max_players"`
+		MinAge      *int32      `json:"min_age"`
+		MaxAge      *int32      `json:"max_age"`
+		PlayTime    *int32      `json:"play_time"`
+		Subject     *string     `json:"subject"`
+		Difficulty  *string     `json:"difficulty"`
+		Publisher   *string     `json:"publisher"`
+		Year        *int32      `json:"year"`
 	}
 	err = app.readJSON(w, r, &input)
 	if err != nil {
@@ -94,14 +123,44 @@ func (app *application) updateGameHandler(w http.ResponseWriter, r *http.Request
 	if input.Title != nil {
 		game.Title = *input.Title
 	}
+	if input.Description != nil {
+		game.Description = *input.Description
+	}
 	if input.Score != nil {
 		game.Score = *input.Score
 	}
 	if input.Games != nil {
 		game.Games = input.Games
 	}
+	if input.MinPlayers != nil {
+		game.MinPlayers = *input.MinPlayers
+	}
+	if input.MaxPlayers != nil {
+		game.MaxPlayers = *input.MaxPlayers
+	}
+	if input.MinAge != nil {
+		game.MinAge = *input.MinAge
+	}
+	if input.MaxAge != nil {
+		game.MaxAge = *input.MaxAge
+	}
+	if input.PlayTime != nil {
+		game.PlayTime = *input.PlayTime
+	}
+	if input.Subject != nil {
+		game.Subject = *input.Subject
+	}
+	if input.Difficulty != nil {
+		game.Difficulty = *input.Difficulty
+	}
+	if input.Publisher != nil {
+		game.Publisher = *input.Publisher
+	}
+	if input.Year != nil {
+		game.Year = *input.Year
+	}
 	v := validator.New()
-	if data.ValidateMovie(v, game); !v.Valid() {
+	if data.ValidateGame(v, game); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
@@ -146,28 +205,49 @@ func (app *application) deleteGameHandler(w http.ResponseWriter, r *http.Request
 
 func (app *application) listGamesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title string
-		Games []string
+		data.GameQuery
 		data.Filters
+		Facets []string
 	}
 	v := validator.New()
 	qs := r.URL.Query()
 	input.Title = app.readString(qs, "title", "")
+	input.Search = app.readString(qs, "search", "fulltext")
 	input.Games = app.readCSV(qs, "games", []string{})
+	input.Players = app.readInt(qs, "players", 0, v)
+	input.Age = app.readInt(qs, "age", 0, v)
+	input.MaxPlayTime = app.readInt(qs, "max_play_time", 0, v)
+	input.Subject = app.readString(qs, "subject", "")
+	input.Difficulty = app.readString(qs, "difficulty", "")
+	input.Facets = app.readCSV(qs, "facets", []string{})
 	input.Page = app.readInt(qs, "page", 1, v)
 	input.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "score", "-id", "-title", "-score"}
+	input.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.SortSafelist = []string{"id", "title", "score", "play_time", "year", "rating", "reviews", "-id", "-title", "-score", "-play_time", "-year", "-rating", "-reviews", "relevance"}
+	input.Filters.SortTypes = data.GameSortTypes
+	data.ValidateGameQuery(v, input.GameQuery)
+	v.Check(input.Sort != "relevance" || input.Title != "", "sort", "relevance requires a title to search for")
+	data.ValidateFacets(v, input.Facets)
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	games, metadata, err := app.models.Games.GetAll(input.Title, input.Games, input.Filters)
+	games, metadata, err := app.models.Games.GetAll(input.GameQuery, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"games": games, "metadata": metadata}, nil)
+	env := envelope{"games": games, "metadata": metadata}
+	if len(input.Facets) > 0 {
+		facets, err := app.models.Games.Facets(input.GameQuery, input.Facets)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["facets"] = facets
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"EBG.IssataySheg.net/internal/data"
 	"EBG.IssataySheg.net/internal/validator"
 	"encoding/json"
 	"errors"
@@ -14,14 +15,23 @@ import (
 )
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 	return id, nil
 }
 
+func (app *application) readStringParam(r *http.Request, name string) string {
+	params := httprouter.ParamsFromContext(r.Context())
+	return params.ByName(name)
+}
+
 type envelope map[string]interface{}
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
@@ -120,3 +130,36 @@ func (app *application) background(fn func()) {
 		fn()
 	}()
 }
+
+// checkPassword validates and checks the password given to re-confirm the
+// user's identity before a sensitive change, writing an error response and
+// returning false if it does not match. Failures count towards the same
+// lockout as failed sign-ins, so that a stolen access token cannot be used to
+// guess the password. A right password does not clear them, since a second
+// factor may still have to be checked; the next complete sign-in does.
+func (app *application) checkPassword(w http.ResponseWriter, r *http.Request, user *data.User, password string) bool {
+	v := validator.New()
+	if data.ValidatePasswordPlaintext(v, password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+	throttle, err := app.models.Throttles.Get(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if throttle.Locked() {
+		app.accountLockedResponse(w, r, *throttle.LockedUntil)
+		return false
+	}
+	match, err := user.Password.Matches(password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !match {
+		app.failedLogin(w, r, user.Email, user)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"EBG.IssataySheg.net/internal/websocket"
+	"sync"
+	"time"
+)
+
+const (
+	socketWriteWait  = 10 * time.Second
+	socketPongWait   = 60 * time.Second
+	socketPingPeriod = 50 * time.Second
+	socketSendBuffer = 16
+)
+
+// socketClient is a participant's WebSocket connection to a play session.
+// Messages queued on send are written by the client's writePump goroutine,
+// and closing send makes writePump close the connection.
+type socketClient struct {
+	userID int64
+	conn   *websocket.Conn
+	send   chan interface{}
+}
+
+func (c *socketClient) writePump() {
+	ticker := time.NewTicker(socketPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if !ok {
+				c.conn.WriteClose(websocket.CloseGoingAway, "")
+				return
+			}
+			if err := c.conn.WriteJSON(message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// hub keeps track of the clients connected to each play session so that
+// updates can be broadcast to every participant.
+type hub struct {
+	mu    sync.Mutex
+	rooms map[int64]map[*socketClient]bool
+}
+
+func newHub() *hub {
+	return &hub{rooms: make(map[int64]map[*socketClient]bool)}
+}
+
+func (h *hub) join(sessionID int64, c *socketClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.rooms[sessionID] == nil {
+		h.rooms[sessionID] = make(map[*socketClient]bool)
+	}
+	h.rooms[sessionID][c] = true
+}
+
+func (h *hub) leave(sessionID int64, c *socketClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(sessionID, c)
+}
+
+// remove must be called with h.mu held.
+func (h *hub) remove(sessionID int64, c *socketClient) {
+	room := h.rooms[sessionID]
+	if !room[c] {
+		return
+	}
+	delete(room, c)
+	close(c.send)
+	if len(room) == 0 {
+		delete(h.rooms, sessionID)
+	}
+}
+
+// broadcast queues message for every client in the session. Clients that are
+// too slow to keep up are disconnected rather than holding up everyone else.
+func (h *hub) broadcast(sessionID int64, message interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.rooms[sessionID] {
+		select {
+		case c.send <- message:
+		default:
+			h.remove(sessionID, c)
+		}
+	}
+}
+
+func (h *hub) send(sessionID int64, c *socketClient, message interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.rooms[sessionID][c] {
+		return
+	}
+	select {
+	case c.send <- message:
+	default:
+		h.remove(sessionID, c)
+	}
+}
+
+func (h *hub) online(sessionID int64) []int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	seen := make(map[int64]bool)
+	userIDs := []int64{}
+	for c := range h.rooms[sessionID] {
+		if !seen[c.userID] {
+			seen[c.userID] = true
+			userIDs = append(userIDs, c.userID)
+		}
+	}
+	return userIDs
+}
+
+// shutdown disconnects every client, which is done when the server stops since
+// http.Server.Shutdown does not track hijacked connections.
+func (h *hub) shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sessionID, room := range h.rooms {
+		for c := range room {
+			h.remove(sessionID, c)
+		}
+	}
+}
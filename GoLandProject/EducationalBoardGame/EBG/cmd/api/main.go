@@ -4,11 +4,16 @@ import (
 	"EBG.IssataySheg.net/internal/data"
 	"EBG.IssataySheg.net/internal/jsonlog"
 	"EBG.IssataySheg.net/internal/mailer"
+	"EBG.IssataySheg.net/internal/oidc"
+	"EBG.IssataySheg.net/internal/rules"
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	_ "github.com/lib/pq"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -40,6 +45,22 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	tokens struct {
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+	}
+	rules        map[int64]string
+	authCacheTTL time.Duration
+	lockout      data.LockoutPolicy
+	exportTTL    time.Duration
+	consentAge   int
+	admin        struct {
+		email string
+	}
+	oidc struct {
+		redirectURL string
+		providers   map[string]oidc.Config
+	}
 }
 
 type application struct {
@@ -47,6 +68,10 @@ type application struct {
 	logger *jsonlog.Logger
 	models data.Models
 	mailer mailer.Mailer
+	hub    *hub
+	auth   *authCache
+	rules  *rules.Registry
+	oidc   map[string]*oidc.Provider
 	wg     sync.WaitGroup
 }
 
@@ -66,11 +91,50 @@ func main() {
 	flag.StringVar(&cfg.smtp.username, "smtp-username", "0abf276416b183", "SMTP username")
 	flag.StringVar(&cfg.smtp.password, "smtp-password", "d8672aa2264bb5", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.alexedwards.net>", "SMTP sender")
+	flag.DurationVar(&cfg.tokens.accessTTL, "access-token-ttl", 15*time.Minute, "Authentication token lifetime")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "refresh-token-ttl", 30*24*time.Hour, "Refresh token lifetime")
+	flag.DurationVar(&cfg.authCacheTTL, "auth-cache-ttl", time.Minute, "How long authenticated users and their permissions are cached (0 disables caching)")
+	flag.IntVar(&cfg.lockout.Threshold, "lockout-threshold", 5, "Failed sign-ins before an account is locked (0 disables lockout)")
+	flag.DurationVar(&cfg.lockout.Base, "lockout-base", time.Minute, "Length of the first lockout, which doubles with each further failure")
+	flag.DurationVar(&cfg.lockout.Max, "lockout-max", time.Hour, "Longest lockout")
+	flag.DurationVar(&cfg.exportTTL, "export-ttl", 7*24*time.Hour, "How long a personal data export can be downloaded for")
+	flag.IntVar(&cfg.consentAge, "consent-age", 13, "Age below which a guardian must consent to an account (0 disables consent)")
+	flag.StringVar(&cfg.admin.email, "admin-email", "", "Email address of an existing user to grant users:admin on startup")
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
 		cfg.cors.trustedOrigins = strings.Fields(val)
 		return nil
 	})
 
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "http://localhost:3000/oidc/callback", "Where OpenID providers send users back to after signing in")
+	flag.Func("oidc-provider", "OpenID provider to sign in with, as name=issuer,client_id,client_secret (may be repeated)", func(val string) error {
+		name, rest, found := strings.Cut(val, "=")
+		fields := strings.Split(rest, ",")
+		if !found || name == "" || len(fields) != 3 {
+			return fmt.Errorf("invalid provider %q", val)
+		}
+		if cfg.oidc.providers == nil {
+			cfg.oidc.providers = make(map[string]oidc.Config)
+		}
+		cfg.oidc.providers[name] = oidc.Config{Issuer: fields[0], ClientID: fields[1], ClientSecret: fields[2]}
+		return nil
+	})
+	engines := strings.Join(rules.Names(), ", ")
+	flag.Func("rules", "Rules engines for catalog games (space separated gameID=engine pairs; engines: "+engines+")", func(val string) error {
+		cfg.rules = make(map[int64]string)
+		for _, pair := range strings.Fields(val) {
+			id, name, found := strings.Cut(pair, "=")
+			gameID, err := strconv.ParseInt(id, 10, 64)
+			if !found || err != nil || gameID < 1 {
+				return fmt.Errorf("invalid gameID=engine pair %q", pair)
+			}
+			if _, err := rules.New(name); err != nil {
+				return fmt.Errorf("unknown engine %q in pair %q (engines: %s)", name, pair, engines)
+			}
+			cfg.rules[gameID] = name
+		}
+		return nil
+	})
+
 	flag.Parse()
 
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
@@ -83,11 +147,28 @@ func main() {
 	defer db.Close()
 	logger.PrintInfo("database connection pool established", nil)
 
+	registry, err := newRulesRegistry(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	app := &application{
 		config: cfg,
 		logger: logger,
 		models: data.NewModels(db),
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		hub:    newHub(),
+		auth:   newAuthCache(cfg.authCacheTTL),
+		rules:  registry,
+		oidc:   newOIDCProviders(cfg),
+	}
+
+	go app.purgeOAuthTokens()
+	go app.purgeExports()
+
+	err = app.bootstrapAdmin()
+	if err != nil {
+		logger.PrintFatal(err, nil)
 	}
 
 	err = app.serve()
@@ -109,3 +190,46 @@ func openDB(cfg config) (*sql.DB, error) {
 	}
 	return db, nil
 }
+
+func newOIDCProviders(cfg config) map[string]*oidc.Provider {
+	providers := make(map[string]*oidc.Provider)
+	for name, provider := range cfg.oidc.providers {
+		provider.RedirectURL = cfg.oidc.redirectURL
+		providers[name] = oidc.NewProvider(provider)
+	}
+	return providers
+}
+
+func newRulesRegistry(cfg config) (*rules.Registry, error) {
+	registry := rules.NewRegistry()
+	for gameID, name := range cfg.rules {
+		engine, err := rules.New(name)
+		if err != nil {
+			return nil, err
+		}
+		registry.Register(gameID, engine)
+	}
+	return registry, nil
+}
+
+// bootstrapAdmin grants users:admin to the user named by the -admin-email flag,
+// which is how the first admin is created. Once there is an admin, further
+// admins can be appointed through the API instead.
+func (app *application) bootstrapAdmin() error {
+	if app.config.admin.email == "" {
+		return nil
+	}
+	user, err := app.models.Users.GetByEmail(app.config.admin.email)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return fmt.Errorf("admin user %q not found, register the account first", app.config.admin.email)
+		}
+		return err
+	}
+	err = app.models.Permissions.AddForUser(user.ID, "users:admin")
+	if err != nil {
+		return err
+	}
+	app.logger.PrintInfo("granted users:admin", map[string]string{"email": user.Email})
+	return nil
+}
@@ -81,17 +81,19 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.invalidAuthenticationTokenResponse(w, r)
+		if len(headerParts) == 2 && headerParts[0] == "Basic" {
+			// Basic credentials identify an OAuth2 client to the token
+			// endpoint, which checks them itself; they never sign a user in.
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
 			return
 		}
-		token := headerParts[1]
-		v := validator.New()
-		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		token := headerParts[1]
+		user, d, err := app.lookupCredential(token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -102,9 +104,66 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
+		if d != nil {
+			r = app.contextSetDelegation(r, d)
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// lookupCredential resolves a bearer credential, which is an authentication
+// token, an API key or an OAuth2 access token, to its user. API keys and OAuth2
+// tokens are told apart by their prefixes. Malformed and unknown credentials
+// give ErrRecordNotFound. Credentials are only touched when they are read from
+// the database, so their last_used_at can lag behind by up to the cache TTL.
+func (app *application) lookupCredential(token string) (*data.User, delegation, error) {
+	key := string(data.HashToken(token))
+	if user, d, found := app.auth.getUser(key); found {
+		return user, d, nil
+	}
+	gen := app.auth.generation()
+	v := validator.New()
+	if strings.HasPrefix(token, data.APIKeyPrefix) {
+		if data.ValidateAPIKeyPlaintext(v, token); !v.Valid() {
+			return nil, nil, data.ErrRecordNotFound
+		}
+		apiKey, user, err := app.models.APIKeys.GetForPlaintext(token)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = app.models.APIKeys.Touch(apiKey.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		app.auth.setUser(key, user, apiKey, gen)
+		return user, apiKey, nil
+	}
+	if strings.HasPrefix(token, data.OAuthAccessTokenPrefix) {
+		if data.ValidateOAuthTokenPlaintext(v, data.OAuthAccessTokenPrefix, token); !v.Valid() {
+			return nil, nil, data.ErrRecordNotFound
+		}
+		oauthToken, user, err := app.models.OAuthTokens.GetForAccessToken(token)
+		if err != nil {
+			return nil, nil, err
+		}
+		app.auth.setUser(key, user, oauthToken, gen)
+		return user, oauthToken, nil
+	}
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		return nil, nil, data.ErrRecordNotFound
+	}
+	user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = app.models.Tokens.Touch(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	app.auth.setUser(key, user, nil, gen)
+	return user, nil, nil
+}
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
@@ -126,10 +185,41 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	})
 	return app.requireAuthenticatedUser(fn)
 }
+
+// requireConsent turns away users who need, but do not yet have, the consent
+// of a parent or guardian. It guards everything that makes a child's content
+// visible to other users, and is meant to be wrapped in one of the middleware
+// which authenticate the user.
+func (app *application) requireConsent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetUser(r).NeedsConsent(app.config.consentAge) {
+			app.consentRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// requireInteractiveUser is like requireAuthenticatedUser but turns away
+// requests made with an API key or OAuth2 access token. It guards the
+// endpoints which manage credentials, so that a leaked key cannot be used to
+// mint more keys or sign the owner out, the users:admin endpoints, which no
+// delegated credential may reach whatever its scopes, and every other endpoint
+// that no permission code covers, since scopes are only checked by
+// requirePermission.
+func (app *application) requireInteractiveUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetDelegation(r) != nil {
+			app.delegationNotAllowedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+	return app.requireAuthenticatedUser(fn)
+}
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		user := app.contextGetUser(r)
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.requestPermissions(r)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"EBG.IssataySheg.net/internal/data"
+	"EBG.IssataySheg.net/internal/oidc"
+	"EBG.IssataySheg.net/internal/validator"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// oauthScopeDescriptions describe permission codes to users on the consent
+// screen. Codes without a description are shown as they are.
+var oauthScopeDescriptions = map[string]string{
+	"games:read":       "See the game catalogue, reviews and your collections",
+	"games:write":      "Add and edit games in the catalogue",
+	"questions:write":  "Write and edit quiz questions",
+	"classrooms:write": "Manage your classrooms and their students",
+	"reviews:moderate": "Moderate other users' reviews",
+}
+
+// oauthAuthorization is an OAuth2 authorization request, which the client
+// sends the user to the consent screen with. The consent screen passes its
+// parameters on to GET and POST /v1/oauth/authorize.
+type oauthAuthorization struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+func (app *application) createOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Public       bool     `json:"public"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	client := &data.OAuthClient{
+		UserID:       app.contextGetUser(r).ID,
+		Name:         input.Name,
+		RedirectURIs: input.RedirectURIs,
+		Scopes:       input.Scopes,
+		Public:       input.Public,
+	}
+	v := validator.New()
+	if data.ValidateOAuthClient(v, client); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	permissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	known := make(map[string]bool, len(permissions))
+	for _, permission := range permissions {
+		known[permission.Code] = true
+	}
+	for _, code := range client.Scopes {
+		v.Check(known[code], "scopes", fmt.Sprintf("unknown permission code %q", code))
+		v.Check(code != "users:admin", "scopes", "users:admin cannot be granted to OAuth clients")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.OAuthClients.Insert(client)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"client": client}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listOAuthClientsHandler(w http.ResponseWriter, r *http.Request) {
+	clients, err := app.models.OAuthClients.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"clients": clients}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.OAuthClients.Delete(app.contextGetUser(r).ID, app.readStringParam(r, "id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// The client's tokens may be cached for any number of users.
+	app.auth.flush()
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "client successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showOAuthAuthorizationHandler checks an authorization request and returns
+// what the consent screen needs to show the user: which app is asking, and
+// for which of their permissions.
+func (app *application) showOAuthAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	request := oauthAuthorization{
+		ResponseType:        app.readString(qs, "response_type", ""),
+		ClientID:            app.readString(qs, "client_id", ""),
+		RedirectURI:         app.readString(qs, "redirect_uri", ""),
+		Scope:               app.readString(qs, "scope", ""),
+		State:               app.readString(qs, "state", ""),
+		CodeChallenge:       app.readString(qs, "code_challenge", ""),
+		CodeChallengeMethod: app.readString(qs, "code_challenge_method", ""),
+	}
+	client, scopes, ok := app.checkOAuthAuthorization(w, r, request)
+	if !ok {
+		return
+	}
+	permissions, err := app.requestPermissions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	type scopeView struct {
+		Code        string `json:"code"`
+		Description string `json:"description"`
+		Held        bool   `json:"held"`
+	}
+	views := make([]scopeView, len(scopes))
+	for i, code := range scopes {
+		description, found := oauthScopeDescriptions[code]
+		if !found {
+			description = code
+		}
+		views[i] = scopeView{Code: code, Description: description, Held: permissions.Include(code)}
+	}
+	env := envelope{
+		"client":       envelope{"client_id": client.ID, "name": client.Name},
+		"scopes":       views,
+		"redirect_uri": request.RedirectURI,
+		"state":        request.State,
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// approveOAuthAuthorizationHandler records the user's answer on the consent
+// screen. It responds with the URL to send the user back to the client with,
+// carrying either an authorization code or an access_denied error.
+func (app *application) approveOAuthAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		oauthAuthorization
+		Approve *bool `json:"approve"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Approve == nil {
+		app.failedValidationResponse(w, r, map[string]string{"approve": "must be provided"})
+		return
+	}
+	client, scopes, ok := app.checkOAuthAuthorization(w, r, input.oauthAuthorization)
+	if !ok {
+		return
+	}
+	reply := url.Values{}
+	if input.State != "" {
+		reply.Set("state", input.State)
+	}
+	if *input.Approve {
+		code := &data.OAuthCode{
+			ClientID:      client.ID,
+			UserID:        app.contextGetUser(r).ID,
+			RedirectURI:   input.RedirectURI,
+			Scopes:        scopes,
+			CodeChallenge: input.CodeChallenge,
+			Expiry:        time.Now().Add(5 * time.Minute),
+		}
+		err = app.models.OAuthTokens.NewCode(code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		reply.Set("code", code.Plaintext)
+	} else {
+		reply.Set("error", "access_denied")
+	}
+	redirectURL, err := url.Parse(input.RedirectURI)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	query := redirectURL.Query()
+	for key, values := range reply {
+		query[key] = values
+	}
+	redirectURL.RawQuery = query.Encode()
+	err = app.writeJSON(w, http.StatusOK, envelope{"redirect_url": redirectURL.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkOAuthAuthorization validates an authorization request, writing an
+// error response and returning false if it is invalid. Every client must use
+// PKCE with the S256 method.
+func (app *application) checkOAuthAuthorization(w http.ResponseWriter, r *http.Request, request oauthAuthorization) (*data.OAuthClient, []string, bool) {
+	v := validator.New()
+	v.Check(request.ClientID != "", "client_id", "must be provided")
+	v.Check(request.RedirectURI != "", "redirect_uri", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, nil, false
+	}
+	client, err := app.models.OAuthClients.Get(request.ClientID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("client_id", "unknown client")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, nil, false
+	}
+	scopes := data.ParseOAuthScope(request.Scope)
+	v.Check(client.AllowsRedirect(request.RedirectURI), "redirect_uri", "is not registered for this client")
+	v.Check(request.ResponseType == "code", "response_type", "must be code")
+	v.Check(request.CodeChallengeMethod == "S256", "code_challenge_method", "must be S256")
+	v.Check(len(request.CodeChallenge) == 43, "code_challenge", "must be a base64url encoded SHA-256 hash")
+	v.Check(len(scopes) > 0, "scope", "must be provided")
+	v.Check(client.AllowsScopes(scopes), "scope", "must only contain scopes registered for this client")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, nil, false
+	}
+	return client, scopes, true
+}
+
+// createOAuthTokenHandler is the OAuth2 token endpoint. Unlike the rest of the
+// API it takes form-encoded parameters and answers in the format RFC 6749
+// prescribes, so that off-the-shelf OAuth2 libraries can talk to it. It
+// supports the authorization_code and refresh_token grants. Each grant starts
+// a token family, and presenting a used refresh token revokes the family.
+func (app *application) createOAuthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	err := r.ParseForm()
+	if err != nil {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "the request body could not be parsed")
+		return
+	}
+	client, ok := app.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+	var pair *data.OAuthTokenPair
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, err := app.models.OAuthTokens.TakeCode(client.ID, r.PostForm.Get("code"))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if code.RedirectURI != r.PostForm.Get("redirect_uri") {
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "redirect_uri does not match the authorization request")
+			return
+		}
+		challenge := oidc.Challenge(r.PostForm.Get("code_verifier"))
+		if subtle.ConstantTimeCompare([]byte(challenge), []byte(code.CodeChallenge)) != 1 {
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code_challenge")
+			return
+		}
+		pair, err = app.models.OAuthTokens.NewPair(client.ID, code.UserID, code.Scopes, app.config.tokens.accessTTL, app.config.tokens.refreshTTL)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	case "refresh_token":
+		var err error
+		pair, err = app.models.OAuthTokens.Rotate(client.ID, r.PostForm.Get("refresh_token"), app.config.tokens.accessTTL, app.config.tokens.refreshTTL)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "invalid or expired refresh token")
+			case errors.Is(err, data.ErrTokenReused):
+				// The revoked family's access tokens may be cached, and the
+				// owner is not known here, so drop everything.
+				app.auth.flush()
+				app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "invalid or expired refresh token")
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		// Rotating revoked the access tokens issued before, which may be
+		// cached.
+		app.auth.invalidateUser(pair.UserID)
+	default:
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+		return
+	}
+	env := envelope{
+		"access_token":  pair.AccessToken,
+		"token_type":    "Bearer",
+		"expires_in":    pair.ExpiresIn,
+		"refresh_token": pair.RefreshToken,
+		"scope":         strings.Join(pair.Scopes, " "),
+	}
+	headers := http.Header{"Cache-Control": {"no-store"}, "Pragma": {"no-cache"}}
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// authenticateOAuthClient identifies the client calling the token endpoint
+// from HTTP Basic credentials or, failing that, the client_id and
+// client_secret form parameters. Public clients send only a client_id.
+func (app *application) authenticateOAuthClient(w http.ResponseWriter, r *http.Request) (*data.OAuthClient, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	client, err := app.models.OAuthClients.Get(clientID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if client == nil || !client.CheckSecret(secret) {
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return nil, false
+	}
+	return client, true
+}
+
+func (app *application) listOAuthAuthorizationsHandler(w http.ResponseWriter, r *http.Request) {
+	authorizations, err := app.models.OAuthTokens.GetAuthorizations(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorizations": authorizations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteOAuthAuthorizationHandler revokes the access the user gave a client.
+func (app *application) deleteOAuthAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	err := app.models.OAuthTokens.DeleteForClient(user.ID, app.readStringParam(r, "client_id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.auth.invalidateUser(user.ID)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "access successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// purgeOAuthTokens deletes expired authorization codes and tokens once an
+// hour, for as long as the server runs.
+func (app *application) purgeOAuthTokens() {
+	for {
+		time.Sleep(time.Hour)
+		err := app.models.OAuthTokens.DeleteExpired()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"EBG.IssataySheg.net/internal/data"
+	"EBG.IssataySheg.net/internal/oidc"
+	"EBG.IssataySheg.net/internal/validator"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+)
+
+var errUnverifiedEmail = errors.New("unverified email")
+
+// oidcSignupError is returned by oidcUser when creating an account needs
+// details which neither the provider nor the client gave. It holds the
+// problems keyed by field, as for a failed validation.
+type oidcSignupError struct {
+	errors map[string]string
+}
+
+func (e *oidcSignupError) Error() string {
+	return "incomplete sign-up"
+}
+
+// oidcSignup holds the details which a client may send along with the code,
+// for use if the sign-in creates an account.
+type oidcSignup struct {
+	DateOfBirth   *data.Date `json:"date_of_birth"`
+	GuardianEmail string     `json:"guardian_email"`
+}
+
+func (app *application) listOIDCProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(app.oidc))
+	for name := range app.oidc {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	err := app.writeJSON(w, http.StatusOK, envelope{"providers": names}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createOIDCLoginHandler starts signing in with an OpenID provider. The client
+// sends the user to the returned URL; the provider sends them back to the
+// configured redirect URL with a code and state, which the client then passes
+// to createOIDCAuthenticationTokenHandler.
+func (app *application) createOIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	name := app.readStringParam(r, "provider")
+	provider, ok := app.oidc[name]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+	login := &data.OIDCLogin{Provider: name, Expiry: time.Now().Add(10 * time.Minute)}
+	for _, s := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		var err error
+		*s, err = oidc.RandomString()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	authURL, err := provider.AuthCodeURL(r.Context(), login.State, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.OIDCLogins.Insert(login)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authorization_url": authURL}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createOIDCAuthenticationTokenHandler finishes signing in with an OpenID
+// provider and issues tokens exactly as a password sign-in would. A sign-in
+// which creates an account needs a date of birth, like registering does. If
+// the provider does not give one, the sign-in fails validation and the client
+// must start again, sending the date of birth along with the code.
+func (app *application) createOIDCAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	name := app.readStringParam(r, "provider")
+	provider, ok := app.oidc[name]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+		oidcSignup
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.Code != "", "code", "must be provided")
+	v.Check(input.State != "", "state", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	login, err := app.models.OIDCLogins.Take(name, input.State)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired sign-in, please start again")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	claims, err := provider.Exchange(r.Context(), input.Code, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrExchange), errors.Is(err, oidc.ErrInvalidToken):
+			app.logError(r, err)
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	user, err := app.oidcUser(r, name, claims, input.oidcSignup)
+	if err != nil {
+		var signupErr *oidcSignupError
+		switch {
+		case errors.Is(err, errUnverifiedEmail):
+			app.unverifiedEmailResponse(w, r)
+		case errors.As(err, &signupErr):
+			app.failedValidationResponse(w, r, signupErr.errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.completeLogin(w, r, user)
+}
+
+// oidcUser returns the user signed in by the provider. The first time a
+// provider identity is seen it is linked to the user with the same email
+// address, who is created if there is none. Since this trusts the provider
+// with the address, it is only done if the provider says it has verified it.
+func (app *application) oidcUser(r *http.Request, provider string, claims *oidc.Claims, signup oidcSignup) (*data.User, error) {
+	user, err := app.models.Identities.GetUser(provider, claims.Subject)
+	if err == nil || !errors.Is(err, data.ErrRecordNotFound) {
+		return user, err
+	}
+	if !claims.EmailVerified || claims.Email == "" {
+		return nil, errUnverifiedEmail
+	}
+	user, err = app.models.Users.GetByEmail(claims.Email)
+	switch {
+	case err == nil:
+		if !user.Activated {
+			err = app.claimUnactivatedUser(r, user)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case errors.Is(err, data.ErrRecordNotFound):
+		user, err = app.registerOIDCUser(r, claims, signup)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	err = app.models.Identities.Insert(&data.Identity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		UserID:   user.ID,
+		Email:    claims.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// claimUnactivatedUser hands an account which was registered but never
+// activated to someone who has just proved, through a provider, that they own
+// its email address. Linking activates the account, since the provider's
+// verification is as good as following the activation link. Whoever chose the
+// account's password never proved they own the address, though, and may have
+// registered it to take the account over once its owner arrives. So the
+// password is replaced with a random one and every token issued so far is
+// revoked; the owner can set a password through a password reset.
+func (app *application) claimUnactivatedUser(r *http.Request, user *data.User) error {
+	password, err := oidc.RandomString()
+	if err != nil {
+		return err
+	}
+	err = user.Password.Set(password)
+	if err != nil {
+		return err
+	}
+	user.Activated = true
+	err = app.models.Users.Update(user)
+	if err != nil {
+		return err
+	}
+	err = app.models.Tokens.DeleteAll(user.ID)
+	if err != nil {
+		return err
+	}
+	app.auth.invalidateUser(user.ID)
+	if user.NeedsConsent(app.config.consentAge) {
+		// Revoking the tokens took the guardian's consent token with it.
+		return app.requestConsent(r, user)
+	}
+	return nil
+}
+
+// registerOIDCUser creates an activated account for someone signing in with a
+// provider for the first time. It gets a random password which nobody knows;
+// the user can set one through a password reset if they want to. The date of
+// birth comes from the provider's birthdate claim where it gives a full date,
+// and otherwise from the client, so that children signing in through a school
+// provider go through the consent workflow like everybody else.
+func (app *application) registerOIDCUser(r *http.Request, claims *oidc.Claims, signup oidcSignup) (*data.User, error) {
+	name := claims.Name
+	if name == "" {
+		name = claims.Email
+	}
+	user := &data.User{
+		Name:          name,
+		Email:         claims.Email,
+		Activated:     true,
+		DateOfBirth:   signup.DateOfBirth,
+		GuardianEmail: signup.GuardianEmail,
+	}
+	if dateOfBirth, err := data.ParseDate(claims.Birthdate); err == nil {
+		user.DateOfBirth = dateOfBirth
+	}
+	password, err := oidc.RandomString()
+	if err != nil {
+		return nil, err
+	}
+	err = user.Password.Set(password)
+	if err != nil {
+		return nil, err
+	}
+	v := validator.New()
+	v.Check(user.DateOfBirth != nil, "date_of_birth", "must be provided to create an account")
+	data.ValidateUser(v, user)
+	data.ValidateGuardian(v, user, app.config.consentAge)
+	if !v.Valid() {
+		return nil, &oidcSignupError{errors: v.Errors}
+	}
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+	err = app.models.Roles.AddForUser(user.ID, "student")
+	if err != nil {
+		return nil, err
+	}
+	if user.NeedsConsent(app.config.consentAge) {
+		err = app.requestConsent(r, user)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
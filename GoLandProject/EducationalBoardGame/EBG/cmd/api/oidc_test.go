@@ -0,0 +1,209 @@
+package main
+
+import (
+	"EBG.IssataySheg.net/internal/data"
+	"EBG.IssataySheg.net/internal/data/datatest"
+	"EBG.IssataySheg.net/internal/jsonlog"
+	"EBG.IssataySheg.net/internal/oidc"
+	"EBG.IssataySheg.net/internal/oidc/oidctest"
+	"EBG.IssataySheg.net/internal/rules"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newOIDCTestApplication returns an application signing in through a fake
+// provider named "test". It needs a database with every migration applied,
+// given by the EBG_TEST_DB_DSN environment variable; without one the test is
+// skipped.
+func newOIDCTestApplication(t *testing.T) (*application, *oidctest.Server) {
+	t.Helper()
+	db := datatest.NewDB(t)
+	srv, err := oidctest.NewServer("ebg", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	var cfg config
+	cfg.tokens.accessTTL = time.Hour
+	cfg.tokens.refreshTTL = 24 * time.Hour
+	cfg.consentAge = 13
+	app := &application{
+		config: cfg,
+		logger: jsonlog.New(io.Discard, jsonlog.LevelOff),
+		models: data.NewModels(db),
+		hub:    newHub(),
+		auth:   newAuthCache(0),
+		rules:  rules.NewRegistry(),
+		oidc:   map[string]*oidc.Provider{"test": oidc.NewProvider(srv.Config("https://app.example/callback"))},
+	}
+	return app, srv
+}
+
+// uniqueEmail returns an address no other test run has used, and deletes its
+// user once the test is over.
+func uniqueEmail(t *testing.T, app *application) string {
+	t.Helper()
+	email := fmt.Sprintf("oidc-%d@example.com", time.Now().UnixNano())
+	t.Cleanup(func() {
+		if user, err := app.models.Users.GetByEmail(email); err == nil {
+			app.models.Users.Delete(user.ID)
+		}
+	})
+	return email
+}
+
+// oidcSignIn goes through the whole sign-in with the provider's current user
+// and returns the response to the final request, which also carries body.
+func oidcSignIn(t *testing.T, app *application, srv *oidctest.Server, body map[string]string) (int, map[string]interface{}) {
+	t.Helper()
+	handler := app.routes()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/oidc/test/authorize", nil))
+	var login struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &login); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("authorize: got %d %s", w.Code, w.Body)
+	}
+	code, state, err := srv.Authorize(login.AuthorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body == nil {
+		body = map[string]string{}
+	}
+	body["code"], body["state"] = code, state
+	js, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/oidc/test/token", bytes.NewReader(js)))
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("token: %v in %s", err, w.Body)
+	}
+	return w.Code, response
+}
+
+func TestOIDCSignInUnverifiedEmail(t *testing.T) {
+	app, srv := newOIDCTestApplication(t)
+	email := uniqueEmail(t, app)
+	srv.SetUser(oidctest.User{Subject: email, Email: email, EmailVerified: false, Birthdate: "1990-01-01"})
+	status, _ := oidcSignIn(t, app, srv, nil)
+	if status != http.StatusForbidden {
+		t.Fatalf("got status %d; want 403", status)
+	}
+	if _, err := app.models.Users.GetByEmail(email); err != data.ErrRecordNotFound {
+		t.Errorf("got error %v looking up the user; want ErrRecordNotFound", err)
+	}
+}
+
+func TestOIDCSignInLinksExistingUser(t *testing.T) {
+	app, srv := newOIDCTestApplication(t)
+	email := uniqueEmail(t, app)
+	user := &data.User{Name: "Alice", Email: email, Activated: true}
+	if err := user.Password.Set("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.Users.Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	srv.SetUser(oidctest.User{Subject: email, Email: email, EmailVerified: true, Name: "Alice at school"})
+	status, response := oidcSignIn(t, app, srv, nil)
+	if status != http.StatusCreated || response["authentication_token"] == nil {
+		t.Fatalf("got %d %v; want 201 with tokens", status, response)
+	}
+	linked, err := app.models.Identities.GetUser("test", email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked.ID != user.ID {
+		t.Errorf("identity linked to user %d; want %d", linked.ID, user.ID)
+	}
+	// An activated account keeps its password.
+	if match, err := linked.Password.Matches("pa55word1234"); err != nil || !match {
+		t.Errorf("password no longer matches")
+	}
+
+	// Signing in again finds the user through the identity.
+	status, _ = oidcSignIn(t, app, srv, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("second sign-in: got status %d; want 201", status)
+	}
+}
+
+func TestOIDCSignInClaimsUnactivatedUser(t *testing.T) {
+	app, srv := newOIDCTestApplication(t)
+	email := uniqueEmail(t, app)
+	user := &data.User{Name: "Squatter", Email: email}
+	if err := user.Password.Set("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.Users.Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	srv.SetUser(oidctest.User{Subject: email, Email: email, EmailVerified: true})
+	status, _ := oidcSignIn(t, app, srv, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d; want 201", status)
+	}
+	claimed, err := app.models.Users.Get(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !claimed.Activated {
+		t.Error("account was not activated")
+	}
+	if match, err := claimed.Password.Matches("pa55word1234"); err != nil || match {
+		t.Error("the password chosen before the owner signed in still works")
+	}
+}
+
+func TestOIDCSignInNewUser(t *testing.T) {
+	app, srv := newOIDCTestApplication(t)
+	email := uniqueEmail(t, app)
+	srv.SetUser(oidctest.User{Subject: email, Email: email, EmailVerified: true, Name: "Bob"})
+	status, response := oidcSignIn(t, app, srv, nil)
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("without a date of birth: got %d %v; want 422", status, response)
+	}
+	if errs, _ := response["error"].(map[string]interface{}); errs["date_of_birth"] == nil {
+		t.Errorf("got %v; want a date_of_birth error", response)
+	}
+
+	status, response = oidcSignIn(t, app, srv, map[string]string{"date_of_birth": "1990-01-01"})
+	if status != http.StatusCreated {
+		t.Fatalf("with a date of birth: got %d %v; want 201", status, response)
+	}
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Activated || user.DateOfBirth == nil || user.DateOfBirth.Format("2006-01-02") != "1990-01-01" {
+		t.Errorf("got user %+v", user)
+	}
+}
+
+func TestOIDCSignInBirthdateClaim(t *testing.T) {
+	app, srv := newOIDCTestApplication(t)
+	email := uniqueEmail(t, app)
+	srv.SetUser(oidctest.User{Subject: email, Email: email, EmailVerified: true, Birthdate: "1985-06-15"})
+	status, response := oidcSignIn(t, app, srv, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("got %d %v; want 201", status, response)
+	}
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.DateOfBirth == nil || user.DateOfBirth.Format("2006-01-02") != "1985-06-15" {
+		t.Errorf("got date of birth %v; want 1985-06-15", user.DateOfBirth)
+	}
+}
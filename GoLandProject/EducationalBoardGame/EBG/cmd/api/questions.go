@@ -0,0 +1,269 @@
+package main
+
+import (
+	"EBG.IssataySheg.net/internal/data"
+	"EBG.IssataySheg.net/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func (app *application) createQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Kind       string   `json:"kind"`
+		Prompt     string   `json:"prompt"`
+		Choices    []string `json:"choices"`
+		Answers    []string `json:"answers"`
+		Tolerance  float64  `json:"tolerance"`
+		Subject    string   `json:"subject"`
+		Difficulty string   `json:"difficulty"`
+		GameIDs    []int64  `json:"game_ids"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	question := &data.Question{
+		Kind:       input.Kind,
+		Prompt:     input.Prompt,
+		Choices:    input.Choices,
+		Answers:    input.Answers,
+		Tolerance:  input.Tolerance,
+		Subject:    input.Subject,
+		Difficulty: input.Difficulty,
+		GameIDs:    input.GameIDs,
+	}
+	if question.GameIDs == nil {
+		question.GameIDs = []int64{}
+	}
+	v := validator.New()
+	if data.ValidateQuestion(v, question); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Questions.Insert(question)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownGame):
+			v.AddError("game_ids", "must only contain IDs of existing games")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/questions/%d", question.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"question": question}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	question, ok := app.readQuestion(w, r)
+	if !ok {
+		return
+	}
+	canWrite, err := app.canWriteQuestions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !canWrite {
+		question.HideAnswers()
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"question": question}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	question, ok := app.readQuestion(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Kind       *string  `json:"kind"`
+		Prompt     *string  `json:"prompt"`
+		Choices    []string `json:"choices"`
+		Answers    []string `json:"answers"`
+		Tolerance  *float64 `json:"tolerance"`
+		Subject    *string  `json:"subject"`
+		Difficulty *string  `json:"difficulty"`
+		GameIDs    []int64  `json:"game_ids"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Kind != nil {
+		question.Kind = *input.Kind
+	}
+	if input.Prompt != nil {
+		question.Prompt = *input.Prompt
+	}
+	if input.Choices != nil {
+		question.Choices = input.Choices
+	}
+	if input.Answers != nil {
+		question.Answers = input.Answers
+	}
+	if input.Tolerance != nil {
+		question.Tolerance = *input.Tolerance
+	}
+	if input.Subject != nil {
+		question.Subject = *input.Subject
+	}
+	if input.Difficulty != nil {
+		question.Difficulty = *input.Difficulty
+	}
+	if input.GameIDs != nil {
+		question.GameIDs = input.GameIDs
+	}
+	v := validator.New()
+	if data.ValidateQuestion(v, question); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Questions.Update(question)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownGame):
+			v.AddError("game_ids", "must only contain IDs of existing games")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"question": question}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Questions.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "question successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listQuestionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.QuestionQuery
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.GameID = int64(app.readInt(qs, "game_id", 0, v))
+	input.Subject = app.readString(qs, "subject", "")
+	input.Difficulty = app.readString(qs, "difficulty", "")
+	input.Kind = app.readString(qs, "kind", "")
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "subject", "difficulty", "-id", "-subject", "-difficulty"}
+	data.ValidateQuestionQuery(v, input.QuestionQuery)
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	questions, metadata, err := app.models.Questions.GetAll(input.QuestionQuery, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	canWrite, err := app.canWriteQuestions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !canWrite {
+		for _, question := range questions {
+			question.HideAnswers()
+		}
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"questions": questions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// answerQuestionHandler checks a player's answer, so that questions can be
+// played without revealing their answers up front.
+func (app *application) answerQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	question, ok := app.readQuestion(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Answer string `json:"answer"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.Answer != "", "answer", "must be provided")
+	v.Check(len(input.Answer) <= 1000, "answer", "must not be more than 1000 bytes long")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"correct": question.Check(input.Answer)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) readQuestion(w http.ResponseWriter, r *http.Request) (*data.Question, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	question, err := app.models.Questions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return question, true
+}
+
+// canWriteQuestions reports whether the current user may see question
+// answers, which only question authors can.
+func (app *application) canWriteQuestions(r *http.Request) (bool, error) {
+	permissions, err := app.requestPermissions(r)
+	if err != nil {
+		return false, err
+	}
+	return permissions.Include("questions:write"), nil
+}
@@ -10,13 +10,104 @@ func (app *application) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodGet, "/debug/vars", app.requireInteractiveUser(app.requirePermission("users:admin", app.authCacheMetricsHandler)))
 	router.HandlerFunc(http.MethodGet, "/v1/games", app.requirePermission("games:read", app.listGamesHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/games", app.requirePermission("games:write", app.createGameHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/games/:id", app.requirePermission("games:read", app.showGameHandler))
 	router.HandlerFunc(http.MethodPatch, "/v1/games/:id", app.requirePermission("games:write", app.updateGameHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/games/:id", app.requirePermission("games:write", app.deleteGameHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/games/:id/reviews", app.requirePermission("games:read", app.listGameReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/games/:id/reviews", app.requirePermission("games:read", app.requireConsent(app.createReviewHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/reviews/:id", app.requirePermission("games:read", app.showReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/reviews/:id", app.requirePermission("games:read", app.requireConsent(app.updateReviewHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/reviews/:id", app.requirePermission("games:read", app.deleteReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/questions", app.requirePermission("games:read", app.listQuestionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/questions", app.requirePermission("questions:write", app.createQuestionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/questions/:id", app.requirePermission("games:read", app.showQuestionHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/questions/:id", app.requirePermission("questions:write", app.updateQuestionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/questions/:id", app.requirePermission("questions:write", app.deleteQuestionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/questions/:id/answer", app.requirePermission("games:read", app.answerQuestionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/collections", app.requirePermission("games:read", app.listCollectionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/collections", app.requirePermission("games:read", app.createCollectionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:id", app.requirePermission("games:read", app.showCollectionHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/collections/:id", app.requirePermission("games:read", app.updateCollectionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id", app.requirePermission("games:read", app.deleteCollectionHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/collections/:id/games/:game_id", app.requirePermission("games:read", app.addCollectionGameHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id/games/:game_id", app.requirePermission("games:read", app.removeCollectionGameHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/collections/:id/order", app.requirePermission("games:read", app.reorderCollectionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/shared/collections/:token", app.showSharedCollectionHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/classrooms", app.requireActivatedUser(app.requireInteractiveUser(app.listClassroomsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/classrooms", app.requirePermission("classrooms:write", app.createClassroomHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/classrooms/:id", app.requireActivatedUser(app.requireInteractiveUser(app.showClassroomHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/classrooms/:id", app.requirePermission("classrooms:write", app.updateClassroomHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/classrooms/:id", app.requirePermission("classrooms:write", app.deleteClassroomHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/classrooms/:id/code", app.requirePermission("classrooms:write", app.regenerateJoinCodeHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/classrooms/:id/students", app.requirePermission("classrooms:write", app.listClassroomStudentsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/classrooms/:id/students/:user_id", app.requirePermission("classrooms:write", app.removeClassroomStudentHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/sessions", app.requirePermission("games:read", app.listSessionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/sessions", app.requirePermission("games:read", app.requireConsent(app.createSessionHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/sessions/:id", app.requirePermission("games:read", app.showSessionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/sessions/:id/players", app.requirePermission("games:read", app.requireConsent(app.invitePlayerHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/sessions/:id/start", app.requirePermission("games:read", app.startSessionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/sessions/:id/ws", app.requirePermission("games:read", app.requireConsent(app.sessionSocketHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requireInteractiveUser(app.requirePermission("users:admin", app.listUsersHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requireInteractiveUser(app.requirePermission("users:admin", app.showUserHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/permissions", app.requireInteractiveUser(app.requirePermission("users:admin", app.listUserPermissionsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requireInteractiveUser(app.requirePermission("users:admin", app.grantUserPermissionsHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requireInteractiveUser(app.requirePermission("users:admin", app.revokeUserPermissionHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles", app.requireInteractiveUser(app.requirePermission("users:admin", app.assignUserRolesHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:role", app.requireInteractiveUser(app.requirePermission("users:admin", app.removeUserRoleHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/lockout", app.requireInteractiveUser(app.requirePermission("users:admin", app.unlockUserHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/export", app.requireInteractiveUser(app.requirePermission("users:admin", app.createUserExportHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/export", app.requireInteractiveUser(app.requirePermission("users:admin", app.showUserExportHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/export/download", app.requireInteractiveUser(app.requirePermission("users:admin", app.downloadUserExportHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/consent", app.requireInteractiveUser(app.requirePermission("users:admin", app.listUserConsentEventsHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requireInteractiveUser(app.requirePermission("users:admin", app.listRolesHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/roles/:code/permissions", app.requireInteractiveUser(app.requirePermission("users:admin", app.updateRolePermissionsHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/permissions", app.requireInteractiveUser(app.requirePermission("users:admin", app.listPermissionsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/permissions", app.requireInteractiveUser(app.requirePermission("users:admin", app.createPermissionHandler)))
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/consent", app.updateConsentHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/consent", app.requireInteractiveUser(app.resendConsentHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireInteractiveUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireActivatedUser(app.requireInteractiveUser(app.updateCurrentUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireInteractiveUser(app.deleteCurrentUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/export", app.requireActivatedUser(app.requireInteractiveUser(app.createExportHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireActivatedUser(app.requireInteractiveUser(app.showExportHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export/download", app.requireActivatedUser(app.requireInteractiveUser(app.downloadExportHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireActivatedUser(app.requireInteractiveUser(app.updateCurrentUserPasswordHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/email", app.requireActivatedUser(app.requireInteractiveUser(app.requestEmailChangeHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/reviews", app.requirePermission("games:read", app.listUserReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/totp", app.requireActivatedUser(app.requireInteractiveUser(app.enrolTOTPHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/totp", app.requireActivatedUser(app.requireInteractiveUser(app.confirmTOTPHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/totp", app.requireActivatedUser(app.requireInteractiveUser(app.deleteTOTPHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/classrooms", app.requireActivatedUser(app.requireInteractiveUser(app.joinClassroomHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/classrooms/:id", app.requireActivatedUser(app.requireInteractiveUser(app.leaveClassroomHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/api-keys", app.requireActivatedUser(app.requireInteractiveUser(app.listAPIKeysHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/api-keys", app.requireActivatedUser(app.requireInteractiveUser(app.createAPIKeyHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/api-keys/:id", app.requireActivatedUser(app.requireInteractiveUser(app.deleteAPIKeyHandler)))
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/tokens/authentication", app.requireInteractiveUser(app.listAuthenticationTokensHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireInteractiveUser(app.deleteCurrentAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/:id", app.requireInteractiveUser(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens", app.requireInteractiveUser(app.deleteAllTokensHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/two-factor", app.createTwoFactorAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc", app.listOIDCProvidersHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oauth/clients", app.requireActivatedUser(app.requireInteractiveUser(app.listOAuthClientsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/clients", app.requireActivatedUser(app.requireInteractiveUser(app.createOAuthClientHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/oauth/clients/:id", app.requireActivatedUser(app.requireInteractiveUser(app.deleteOAuthClientHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/oauth/authorize", app.requireActivatedUser(app.requireInteractiveUser(app.showOAuthAuthorizationHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/authorize", app.requireActivatedUser(app.requireInteractiveUser(app.requireConsent(app.approveOAuthAuthorizationHandler))))
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/token", app.createOAuthTokenHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/oauth", app.requireActivatedUser(app.requireInteractiveUser(app.listOAuthAuthorizationsHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/oauth/:client_id", app.requireActivatedUser(app.requireInteractiveUser(app.deleteOAuthAuthorizationHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/oidc/:provider/authorize", app.createOIDCLoginHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/oidc/:provider/token", app.createOIDCAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
 	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))
 }
@@ -37,6 +37,7 @@ func (app *application) serve() error {
 		if err != nil {
 			shutdownError <- err
 		}
+		app.hub.shutdown()
 		app.logger.PrintInfo("completing background tasks", map[string]string{
 			"addr": srv.Addr,
 		})
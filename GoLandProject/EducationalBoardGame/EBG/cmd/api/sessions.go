@@ -0,0 +1,449 @@
+package main
+
+import (
+	"EBG.IssataySheg.net/internal/data"
+	"EBG.IssataySheg.net/internal/rules"
+	"EBG.IssataySheg.net/internal/validator"
+	"EBG.IssataySheg.net/internal/websocket"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// socketMessage is the JSON message exchanged with play session clients.
+// Clients send "move" messages and may ask for their legal "moves"; the server
+// sends "session" snapshots after every change, "presence" updates, the
+// requested "moves" and "error" replies.
+//
+// For games with a rules engine a move is described by Move and validated by
+// the engine. Other games are refereed by the players, who send the whole new
+// State on their turn.
+type socketMessage struct {
+	Type    string          `json:"type"`
+	Session *data.Session   `json:"session,omitempty"`
+	Online  []int64         `json:"online,omitempty"`
+	Move    rules.Move      `json:"move,omitempty"`
+	Moves   []rules.Move    `json:"moves,omitempty"`
+	State   json.RawMessage `json:"state,omitempty"`
+	Message string          `json:"message,omitempty"`
+}
+
+func (app *application) createSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		GameID  int64   `json:"game_id"`
+		Players []int64 `json:"players"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	v := validator.New()
+	v.Check(input.GameID > 0, "game_id", "must be provided")
+	v.Check(validator.Unique(append(input.Players, user.ID)), "players", "must not contain duplicate values or yourself")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	game, err := app.models.Games.Get(input.GameID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("game_id", "no game matching this ID exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if game.MaxPlayers > 0 && len(input.Players)+1 > int(game.MaxPlayers) {
+		v.AddError("players", fmt.Sprintf("must not contain more than %d players including yourself", game.MaxPlayers))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	session := &data.Session{
+		GameID: game.ID,
+		HostID: user.ID,
+		Status: data.SessionWaiting,
+	}
+	err = app.models.Sessions.Insert(session, input.Players)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("players", "must only contain IDs of existing users")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/sessions/%d", session.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"session": session}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-id")
+	filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	sessions, metadata, err := app.models.Sessions.GetAllForUser(app.contextGetUser(r).ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := app.readParticipantSession(w, r)
+	if !ok {
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"session": session, "online": app.hub.online(session.ID)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) invitePlayerHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := app.readParticipantSession(w, r)
+	if !ok {
+		return
+	}
+	if session.HostID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+	var input struct {
+		UserID int64 `json:"user_id"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.UserID > 0, "user_id", "must be provided")
+	v.Check(session.Status == data.SessionWaiting, "session", "players can only be invited before the session starts")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	game, err := app.models.Games.Get(session.GameID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if game.MaxPlayers > 0 && len(session.Players) >= int(game.MaxPlayers) {
+		v.AddError("user_id", fmt.Sprintf("the session already has the maximum of %d players", game.MaxPlayers))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Sessions.AddPlayer(session, input.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicatePlayer):
+			v.AddError("user_id", "this user has already been invited")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("user_id", "no user matching this ID exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.hub.broadcast(session.ID, socketMessage{Type: "session", Session: session})
+	err = app.writeJSON(w, http.StatusOK, envelope{"session": session}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) startSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := app.readParticipantSession(w, r)
+	if !ok {
+		return
+	}
+	if session.HostID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+	v := validator.New()
+	if session.Status != data.SessionWaiting {
+		v.AddError("session", "has already started")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	game, err := app.models.Games.Get(session.GameID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Invited users who never joined are dropped when the session starts, so
+	// that turns only pass between players who are present. The host counts as
+	// present whether or not they have opened the socket.
+	present := []data.SessionPlayer{}
+	for _, player := range session.Players {
+		if player.JoinedAt != nil || player.UserID == session.HostID {
+			present = append(present, player)
+		}
+	}
+	if len(present) < int(game.MinPlayers) {
+		v.AddError("session", fmt.Sprintf("at least %d players must join before the session starts", game.MinPlayers))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	session.Status = data.SessionActive
+	session.Turn = present[0].Seat
+	if engine, ok := app.rules.Lookup(session.GameID); ok {
+		players := make([]int64, len(present))
+		for i, player := range present {
+			players[i] = player.UserID
+		}
+		session.State, err = engine.NewState(players)
+		if err != nil {
+			switch {
+			case errors.Is(err, rules.ErrWrongPlayerCount):
+				v.AddError("session", err.Error())
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+	err = app.models.Sessions.Start(session)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.hub.broadcast(session.ID, socketMessage{Type: "session", Session: session})
+	err = app.writeJSON(w, http.StatusOK, envelope{"session": session}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// sessionSocketHandler upgrades a participant's request to a WebSocket and
+// relays their moves until they disconnect. The request has already been
+// authenticated with a bearer token by the authenticate middleware.
+func (app *application) sessionSocketHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := app.readParticipantSession(w, r)
+	if !ok {
+		return
+	}
+	user := app.contextGetUser(r)
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	err = app.models.Sessions.MarkJoined(session, user.ID)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		conn.WriteClose(websocket.CloseInternalErr, "")
+		conn.Close()
+		return
+	}
+	client := &socketClient{
+		userID: user.ID,
+		conn:   conn,
+		send:   make(chan interface{}, socketSendBuffer),
+	}
+	app.hub.join(session.ID, client)
+	app.background(client.writePump)
+	defer func() {
+		app.hub.leave(session.ID, client)
+		app.hub.broadcast(session.ID, socketMessage{Type: "presence", Online: app.hub.online(session.ID)})
+	}()
+	app.hub.broadcast(session.ID, socketMessage{Type: "session", Session: session})
+	app.hub.broadcast(session.ID, socketMessage{Type: "presence", Online: app.hub.online(session.ID)})
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(socketPongWait))
+		messageType, payload, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+		var message socketMessage
+		err = json.Unmarshal(payload, &message)
+		if err != nil {
+			app.hub.send(session.ID, client, socketMessage{Type: "error", Message: "message must be a JSON object"})
+			continue
+		}
+		switch message.Type {
+		case "move":
+			updated, err := app.applySessionMove(session.ID, user.ID, message)
+			if err != nil {
+				app.hub.send(session.ID, client, socketMessage{Type: "error", Message: err.Error()})
+				continue
+			}
+			app.hub.broadcast(session.ID, socketMessage{Type: "session", Session: updated})
+		case "moves":
+			moves, err := app.legalSessionMoves(session.ID, user.ID)
+			if err != nil {
+				app.hub.send(session.ID, client, socketMessage{Type: "error", Message: err.Error()})
+				continue
+			}
+			app.hub.send(session.ID, client, socketMessage{Type: "moves", Moves: moves})
+		default:
+			app.hub.send(session.ID, client, socketMessage{Type: "error", Message: fmt.Sprintf("unknown message type %q", message.Type)})
+		}
+	}
+}
+
+// errSessionInternal is reported to a client in place of errors which should
+// not be exposed to it; the underlying error is logged.
+var errSessionInternal = errors.New("the server encountered a problem and could not process your move")
+
+func (app *application) applySessionMove(sessionID, userID int64, message socketMessage) (*data.Session, error) {
+	session, err := app.models.Sessions.Get(sessionID)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return nil, errSessionInternal
+	}
+	if session.Status != data.SessionActive {
+		return nil, errors.New("the session is not in progress")
+	}
+	engine, ok := app.rules.Lookup(session.GameID)
+	if !ok {
+		switch {
+		case session.CurrentPlayer() != userID:
+			return nil, errors.New("it is not your turn")
+		case !json.Valid(message.State) || len(message.State) == 0 || message.State[0] != '{':
+			return nil, errors.New("state must be a JSON object")
+		}
+		session.State = message.State
+		session.AdvanceTurn()
+	} else {
+		err = app.refereeSessionMove(engine, session, userID, message.Move)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = app.models.Sessions.Update(session)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return nil, errors.New("the session changed while your move was being made, please try again")
+		default:
+			app.logger.PrintError(err, nil)
+			return nil, errSessionInternal
+		}
+	}
+	return session, nil
+}
+
+// refereeSessionMove has the engine validate and apply a move, then updates
+// the session's turn and status to match the new state.
+func (app *application) refereeSessionMove(engine rules.Engine, session *data.Session, userID int64, move rules.Move) error {
+	if len(move) == 0 {
+		return errors.New("move must be provided")
+	}
+	state, err := engine.Apply(session.State, userID, move)
+	if err != nil {
+		switch {
+		case errors.Is(err, rules.ErrIllegalMove), errors.Is(err, rules.ErrNotYourTurn), errors.Is(err, rules.ErrGameOver),
+			errors.Is(err, rules.ErrInvalidState):
+			return err
+		default:
+			app.logger.PrintError(err, nil)
+			return errSessionInternal
+		}
+	}
+	session.State = state
+	_, over, err := engine.Winner(state)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return errSessionInternal
+	}
+	if over {
+		session.Status = data.SessionFinished
+		return nil
+	}
+	current, err := engine.CurrentPlayer(state)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return errSessionInternal
+	}
+	if player := session.Player(current); player != nil {
+		session.Turn = player.Seat
+	}
+	return nil
+}
+
+func (app *application) legalSessionMoves(sessionID, userID int64) ([]rules.Move, error) {
+	session, err := app.models.Sessions.Get(sessionID)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return nil, errSessionInternal
+	}
+	engine, ok := app.rules.Lookup(session.GameID)
+	if !ok {
+		return nil, errors.New("this game has no rules engine to list moves")
+	}
+	if session.Status != data.SessionActive {
+		return []rules.Move{}, nil
+	}
+	moves, err := engine.LegalMoves(session.State, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, rules.ErrInvalidState):
+			return nil, err
+		default:
+			app.logger.PrintError(err, nil)
+			return nil, errSessionInternal
+		}
+	}
+	return moves, nil
+}
+
+// readParticipantSession fetches the session named by the id URL parameter,
+// responding with 404 Not Found unless the current user is one of its players.
+func (app *application) readParticipantSession(w http.ResponseWriter, r *http.Request) (*data.Session, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	session, err := app.models.Sessions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	if session.Player(app.contextGetUser(r).ID) == nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	return session, true
+}
@@ -25,11 +25,20 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	throttle, err := app.models.Throttles.Get(input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if throttle.Locked() {
+		app.accountLockedResponse(w, r, *throttle.LockedUntil)
+		return
+	}
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+			app.failedLogin(w, r, input.Email, nil)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -41,15 +50,329 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 	if !match {
+		app.failedLogin(w, r, input.Email, user)
+		return
+	}
+	app.completeLogin(w, r, user)
+}
+
+// completeLogin issues tokens to a user who has proved who they are, unless
+// they use two-factor authentication, in which case they are given a token to
+// finish signing in with their second factor. Failed sign-ins are only
+// forgotten once the tokens are issued, so that a wrong second factor keeps
+// counting towards the lockout.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User) {
+	totp, err := app.models.TOTP.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if totp != nil && totp.Confirmed {
+		token, err := app.models.Tokens.New(user.ID, 5*time.Minute, data.ScopeTwoFactor)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env := envelope{
+			"two_factor_token": token,
+			"message":          "send a code from your authenticator app to POST /v1/tokens/two-factor to finish signing in",
+		}
+		err = app.writeJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.Throttles.Reset(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.writeTokenPair(w, r, user)
+}
+
+// failedLogin records a failed sign-in for the address and responds. When the
+// failure locks the account for the first time in a run of failures, the
+// owner, if there is one, is sent an email about it.
+func (app *application) failedLogin(w http.ResponseWriter, r *http.Request, email string, user *data.User) {
+	throttle, err := app.models.Throttles.RecordFailure(email, app.config.lockout)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !throttle.Locked() {
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	if user != nil && throttle.Failures == app.config.lockout.Threshold {
+		app.background(func() {
+			data := map[string]interface{}{
+				"lockedUntil": throttle.LockedUntil.Format(time.RFC1123),
+			}
+			err := app.mailer.Send(user.Email, "account_locked.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+	app.accountLockedResponse(w, r, *throttle.LockedUntil)
+}
+
+// createTwoFactorAuthenticationTokenHandler is the second step of signing in
+// for users with two-factor authentication. It takes the token issued by
+// createAuthenticationTokenHandler along with either a TOTP code or a recovery
+// code. A two-factor token can only be tried once, so a wrong code means
+// starting again with the password, and wrong codes count towards the lockout.
+func (app *application) createTwoFactorAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	if input.RecoveryCode == "" {
+		data.ValidateTOTPCode(v, input.Code)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, err := app.models.Users.GetForToken(data.ScopeTwoFactor, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeTwoFactor, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !app.checkSecondFactor(w, r, user, input.Code, input.RecoveryCode) {
+		return
+	}
+	app.writeTokenPair(w, r, user)
+}
+
+// writeTokenPair signs the user in on a new device, responding with a fresh
+// access token and refresh token.
+func (app *application) writeTokenPair(w http.ResponseWriter, r *http.Request, user *data.User) {
+	pair, err := app.models.Tokens.NewPair(user.ID, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, r.UserAgent())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": pair.Access, "refresh_token": pair.Refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// refreshTokenHandler exchanges a refresh token for a new access token and
+// refresh token. Presenting a refresh token which has already been exchanged
+// signs the device out entirely, since it means the token has leaked.
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	pair, err := app.models.Tokens.Rotate(input.RefreshToken, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, r.UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data.ErrTokenReused):
+			// The revoked family's access tokens may be cached, and the
+			// owner is not known here, so drop everything.
+			app.auth.flush()
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.auth.invalidateUser(pair.Access.UserID)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": pair.Access, "refresh_token": pair.Refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasswordResetTokenHandler emails a password reset token to the given
+// address. It responds the same way whether or not the address belongs to an
+// activated account, so that it cannot be used to discover registered users.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, err := app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil && user.Activated:
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.background(func() {
+			data := map[string]interface{}{
+				"passwordResetToken": token.Plaintext,
+			}
+			err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelope{"message": "if the address belongs to an activated account, an email will be sent to you containing password reset instructions"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createActivationTokenHandler resends an activation email for an account
+// which has not been activated yet, replacing any earlier activation tokens.
+// Like createPasswordResetTokenHandler it gives the same response for every
+// address.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, err := app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil && !user.Activated:
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.background(func() {
+			data := map[string]interface{}{
+				"activationToken": token.Plaintext,
+			}
+			err := app.mailer.Send(user.Email, "token_activation.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelope{"message": "if the address belongs to an account awaiting activation, an email will be sent to you containing activation instructions"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	tokens, err := app.models.Tokens.GetAllForUser(app.contextGetUser(r).ID, app.contextGetToken(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"authentication_tokens": tokens}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCurrentAuthenticationTokenHandler logs out by revoking the token the
+// request was authenticated with, along with the rest of its family.
+func (app *application) deleteCurrentAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Tokens.DeleteFamilyForToken(app.contextGetToken(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.auth.invalidateUser(app.contextGetUser(r).ID)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Tokens.DeleteFamily(app.contextGetUser(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.auth.invalidateUser(app.contextGetUser(r).ID)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllTokensHandler logs the user out everywhere by revoking every sign-in
+// token they hold, including the one used for this request.
+func (app *application) deleteAllTokensHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Tokens.DeleteSignIns(app.contextGetUser(r).ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	app.auth.invalidateUser(app.contextGetUser(r).ID)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out on all devices"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"EBG.IssataySheg.net/internal/data"
+	"EBG.IssataySheg.net/internal/totp"
+	"EBG.IssataySheg.net/internal/validator"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const totpIssuer = "EducationalBoardGame"
+
+// enrolTOTPHandler starts enabling two-factor authentication by generating a
+// secret for the user's authenticator app. Nothing changes at sign-in until the
+// enrolment is confirmed with confirmTOTPHandler.
+func (app *application) enrolTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	if !app.checkPassword(w, r, user, input.Password) {
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	enrolment := &data.TOTP{UserID: user.ID, Secret: secret}
+	err = app.models.TOTP.Enrol(enrolment)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTOTPEnabled):
+			app.totpEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	env := envelope{
+		"secret": secret,
+		"uri":    totp.URI(totpIssuer, user.Email, secret),
+	}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmTOTPHandler switches two-factor authentication on once the user has
+// shown a valid code, and responds with their recovery codes. This is the
+// only time the recovery codes are shown.
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user := app.contextGetUser(r)
+	enrolment, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if enrolment.Confirmed {
+		app.totpEnabledResponse(w, r)
+		return
+	}
+	step, ok := totp.Validate(enrolment.Secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "invalid or expired code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	recoveryCodes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.TOTP.Confirm(enrolment, step, recoveryCodes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTOTPEnabled):
+			app.totpEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteTOTPHandler switches two-factor authentication off. It asks for the
+// password and a current code, or a recovery code, so that a stolen access
+// token alone is not enough.
+func (app *application) deleteTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	if !app.checkPassword(w, r, user, input.Password) {
+		return
+	}
+	// Without a confirmed enrolment there is no code to give, and a failed
+	// check would count towards the lockout.
+	enrolment, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !enrolment.Confirmed {
+		app.notFoundResponse(w, r)
+		return
+	}
+	if !app.checkSecondFactor(w, r, user, input.Code, input.RecoveryCode) {
+		return
+	}
+	err = app.models.TOTP.Delete(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkSecondFactor checks a TOTP code or recovery code, writing an error
+// response and returning false if it is wrong. Wrong codes count towards the
+// same lockout as wrong passwords, and the failures are only cleared once the
+// code is right, so knowing the password is not enough to guess codes freely.
+func (app *application) checkSecondFactor(w http.ResponseWriter, r *http.Request, user *data.User, code, recoveryCode string) bool {
+	throttle, err := app.models.Throttles.Get(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if throttle.Locked() {
+		app.accountLockedResponse(w, r, *throttle.LockedUntil)
+		return false
+	}
+	ok, err := app.checkTwoFactorCode(user.ID, code, recoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !ok {
+		app.failedLogin(w, r, user.Email, user)
+		return false
+	}
+	err = app.models.Throttles.Reset(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	return true
+}
+
+// checkTwoFactorCode reports whether the user gave a valid TOTP code, or
+// failing that a valid recovery code. Either is used up by a successful check.
+func (app *application) checkTwoFactorCode(userID int64, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		return app.models.TOTP.UseRecoveryCode(userID, recoveryCode)
+	}
+	enrolment, err := app.models.TOTP.Get(userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !enrolment.Confirmed {
+		return false, nil
+	}
+	step, ok := totp.Validate(enrolment.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return app.models.TOTP.UseStep(userID, step)
+}
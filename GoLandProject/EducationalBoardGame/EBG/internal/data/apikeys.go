@@ -0,0 +1,233 @@
+package data
+
+import (
+	"EBG.IssataySheg.net/internal/validator"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+// APIKeyPrefix starts every API key, which is how authenticate tells them
+// apart from authentication tokens.
+const APIKeyPrefix = "ebg_"
+
+var ErrDuplicateAPIKey = errors.New("duplicate api key")
+
+// APIKey is a long-lived credential for scripts and integrations. It grants
+// only the permission codes in Scopes, and only while the owner still holds
+// them. The plaintext is only available when the key is created.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UserID     int64      `json:"-"`
+	Name       string     `json:"name"`
+	Plaintext  string     `json:"key,omitempty"`
+	Prefix     string     `json:"prefix"`
+	Hash       []byte     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	Expiry     *time.Time `json:"expiry,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Permissions narrows the owner's permissions down to the key's scopes.
+func (k *APIKey) Permissions(owner Permissions) Permissions {
+	return owner.Restrict(k.Scopes)
+}
+
+func generateAPIKey(userID int64, name string, scopes []string, expiry *time.Time) (*APIKey, error) {
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := APIKeyPrefix + strings.ToLower(base32.StdEncoding.EncodeToString(randomBytes))
+	return &APIKey{
+		UserID:    userID,
+		Name:      name,
+		Plaintext: plaintext,
+		Prefix:    plaintext[:len(APIKeyPrefix)+6],
+		Hash:      HashToken(plaintext),
+		Scopes:    scopes,
+		Expiry:    expiry,
+	}, nil
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(key.Scopes) > 0, "scopes", "must contain at least 1 permission code")
+	v.Check(validator.Unique(key.Scopes), "scopes", "must not contain duplicate values")
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(strings.HasPrefix(plaintext, APIKeyPrefix), "key", "must start with "+APIKeyPrefix)
+	v.Check(len(plaintext) == len(APIKeyPrefix)+32, "key", "must be 36 bytes long")
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// New generates a key for the user and stores its hash. The returned key is
+// the only place the plaintext is available.
+func (m APIKeyModel) New(userID int64, name string, scopes []string, expiry *time.Time) (*APIKey, error) {
+	key, err := generateAPIKey(userID, name, scopes, expiry)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, hash, scopes, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+	args := []interface{}{key.UserID, key.Name, key.Prefix, key.Hash, pq.Array(key.Scopes), key.Expiry}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "api_keys_user_id_name_key"`:
+			return nil, ErrDuplicateAPIKey
+		default:
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// GetForPlaintext returns an unexpired key along with its owner.
+func (m APIKeyModel) GetForPlaintext(plaintext string) (*APIKey, *User, error) {
+	query := `
+		SELECT api_keys.id, api_keys.created_at, api_keys.user_id, api_keys.name, api_keys.prefix,
+		       api_keys.scopes, api_keys.expiry, api_keys.last_used_at,
+		       users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version,
+		       users.date_of_birth, users.guardian_email, users.consented_at
+		FROM api_keys
+		INNER JOIN users ON users.id = api_keys.user_id
+		WHERE api_keys.hash = $1
+		AND (api_keys.expiry IS NULL OR api_keys.expiry > $2)`
+	var (
+		key  APIKey
+		user User
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, HashToken(plaintext), time.Now()).Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Scopes),
+		&key.Expiry,
+		&key.LastUsedAt,
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.DateOfBirth,
+		&user.GuardianEmail,
+		&user.ConsentedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+	return &key, &user, nil
+}
+
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT id, created_at, user_id, name, prefix, scopes, expiry, last_used_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(
+			&key.ID,
+			&key.CreatedAt,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			pq.Array(&key.Scopes),
+			&key.Expiry,
+			&key.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (m APIKeyModel) Delete(userID, id int64) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE user_id = $1 AND id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteAllForUser revokes every key the user holds. It is done whenever the
+// password is reset or changed, since a key minted by someone who knew the old
+// password would otherwise outlive it.
+func (m APIKeyModel) DeleteAllForUser(userID int64) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE user_id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// Touch records that the key has just been used, at most once a minute.
+func (m APIKeyModel) Touch(id int64) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
@@ -0,0 +1,325 @@
+package data
+
+import (
+	"EBG.IssataySheg.net/internal/validator"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// joinCodeAlphabet leaves out characters which are easily confused when a
+// code is read out in class, such as 0 and O or 1 and I.
+const (
+	joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	joinCodeLength   = 8
+	joinCodeAttempts = 3
+)
+
+var (
+	ErrDuplicateStudent = errors.New("duplicate student")
+	errDuplicateCode    = errors.New("duplicate join code")
+)
+
+type Classroom struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	TeacherID    int64     `json:"teacher_id"`
+	Name         string    `json:"name"`
+	JoinCode     string    `json:"join_code,omitempty"`
+	StudentCount int32     `json:"student_count"`
+	Version      int32     `json:"version"`
+}
+
+type Student struct {
+	UserID   int64     `json:"user_id"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+func generateJoinCode() (string, error) {
+	code := make([]byte, joinCodeLength)
+	max := big.NewInt(int64(len(joinCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = joinCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+func ValidateClassroom(v *validator.Validator, classroom *Classroom) {
+	v.Check(classroom.Name != "", "name", "must be provided")
+	v.Check(len(classroom.Name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+func ValidateJoinCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == joinCodeLength, "code", "must be 8 characters long")
+}
+
+type ClassroomModel struct {
+	DB *sql.DB
+}
+
+// Insert creates the classroom with a freshly generated join code.
+func (m ClassroomModel) Insert(classroom *Classroom) error {
+	query := `
+		INSERT INTO classrooms (teacher_id, name, join_code)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, version`
+	return m.withJoinCode(classroom, func(ctx context.Context) error {
+		args := []interface{}{classroom.TeacherID, classroom.Name, classroom.JoinCode}
+		return m.DB.QueryRowContext(ctx, query, args...).Scan(&classroom.ID, &classroom.CreatedAt, &classroom.Version)
+	})
+}
+
+// RegenerateJoinCode replaces the classroom's join code, so that the old code
+// can no longer be used to join.
+func (m ClassroomModel) RegenerateJoinCode(classroom *Classroom) error {
+	query := `
+		UPDATE classrooms
+		SET join_code = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+	return m.withJoinCode(classroom, func(ctx context.Context) error {
+		err := m.DB.QueryRowContext(ctx, query, classroom.JoinCode, classroom.ID, classroom.Version).Scan(&classroom.Version)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEditConflict
+		}
+		return err
+	})
+}
+
+// withJoinCode runs fn with a new join code set on the classroom, retrying
+// with another code in the unlikely event that it is already in use.
+func (m ClassroomModel) withJoinCode(classroom *Classroom, fn func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		code, err := generateJoinCode()
+		if err != nil {
+			return err
+		}
+		classroom.JoinCode = code
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		err = fn(ctx)
+		cancel()
+		if err != nil && err.Error() == `pq: duplicate key value violates unique constraint "classrooms_join_code_key"` {
+			err = errDuplicateCode
+		}
+		if !errors.Is(err, errDuplicateCode) || attempt == joinCodeAttempts {
+			return err
+		}
+	}
+}
+
+func (m ClassroomModel) Get(id int64) (*Classroom, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	return m.get("id = $1", id)
+}
+
+func (m ClassroomModel) GetByJoinCode(code string) (*Classroom, error) {
+	return m.get("join_code = $1", code)
+}
+
+func (m ClassroomModel) get(condition string, arg interface{}) (*Classroom, error) {
+	query := `
+		SELECT id, created_at, teacher_id, name, join_code,
+		       (SELECT count(*) FROM classrooms_students WHERE classroom_id = classrooms.id), version
+		FROM classrooms
+		WHERE ` + condition
+	var classroom Classroom
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, arg).Scan(
+		&classroom.ID,
+		&classroom.CreatedAt,
+		&classroom.TeacherID,
+		&classroom.Name,
+		&classroom.JoinCode,
+		&classroom.StudentCount,
+		&classroom.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &classroom, nil
+}
+
+// GetAllForTeacher returns the classrooms taught by the user.
+func (m ClassroomModel) GetAllForTeacher(userID int64) ([]*Classroom, error) {
+	return m.getAll("teacher_id = $1", userID)
+}
+
+// GetAllForStudent returns the classrooms the user has joined as a student.
+func (m ClassroomModel) GetAllForStudent(userID int64) ([]*Classroom, error) {
+	return m.getAll("id IN (SELECT classroom_id FROM classrooms_students WHERE user_id = $1)", userID)
+}
+
+func (m ClassroomModel) getAll(condition string, userID int64) ([]*Classroom, error) {
+	query := `
+		SELECT id, created_at, teacher_id, name, join_code,
+		       (SELECT count(*) FROM classrooms_students WHERE classroom_id = classrooms.id), version
+		FROM classrooms
+		WHERE ` + condition + `
+		ORDER BY name ASC, id ASC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	classrooms := []*Classroom{}
+	for rows.Next() {
+		var classroom Classroom
+		err := rows.Scan(
+			&classroom.ID,
+			&classroom.CreatedAt,
+			&classroom.TeacherID,
+			&classroom.Name,
+			&classroom.JoinCode,
+			&classroom.StudentCount,
+			&classroom.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		classrooms = append(classrooms, &classroom)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return classrooms, nil
+}
+
+func (m ClassroomModel) Update(classroom *Classroom) error {
+	query := `
+		UPDATE classrooms
+		SET name = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, classroom.Name, classroom.ID, classroom.Version).Scan(&classroom.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m ClassroomModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM classrooms
+		WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m ClassroomModel) AddStudent(classroomID, userID int64) error {
+	query := `
+		INSERT INTO classrooms_students (classroom_id, user_id)
+		VALUES ($1, $2)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, classroomID, userID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "classrooms_students_pkey"`:
+			return ErrDuplicateStudent
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m ClassroomModel) RemoveStudent(classroomID, userID int64) error {
+	query := `
+		DELETE FROM classrooms_students
+		WHERE classroom_id = $1 AND user_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, classroomID, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m ClassroomModel) IsStudent(classroomID, userID int64) (bool, error) {
+	query := `
+		SELECT EXISTS(SELECT 1 FROM classrooms_students WHERE classroom_id = $1 AND user_id = $2)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query, classroomID, userID).Scan(&exists)
+	return exists, err
+}
+
+func (m ClassroomModel) GetStudents(classroomID int64) ([]*Student, error) {
+	query := `
+		SELECT users.id, users.name, users.email, classrooms_students.joined_at
+		FROM classrooms_students
+		INNER JOIN users ON users.id = classrooms_students.user_id
+		WHERE classrooms_students.classroom_id = $1
+		ORDER BY users.name ASC, users.id ASC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, classroomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	students := []*Student{}
+	for rows.Next() {
+		var student Student
+		err := rows.Scan(&student.UserID, &student.Name, &student.Email, &student.JoinedAt)
+		if err != nil {
+			return nil, err
+		}
+		students = append(students, &student)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return students, nil
+}
@@ -0,0 +1,310 @@
+package data
+
+import (
+	"EBG.IssataySheg.net/internal/validator"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"github.com/lib/pq"
+	"time"
+)
+
+var (
+	ErrDuplicateCollection = errors.New("duplicate collection")
+	ErrInvalidOrder        = errors.New("invalid order")
+)
+
+type Collection struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UserID      int64     `json:"-"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	ShareToken  string    `json:"share_token,omitempty"`
+	GameCount   int32     `json:"game_count"`
+	Version     int32     `json:"version"`
+}
+
+func (c *Collection) IsPublic() bool {
+	return c.ShareToken != ""
+}
+
+// Share makes the collection viewable by anybody holding its share token,
+// generating a new unguessable token if it does not already have one.
+func (c *Collection) Share() error {
+	if c.IsPublic() {
+		return nil
+	}
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+	c.ShareToken = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	return nil
+}
+
+func (c *Collection) Unshare() {
+	c.ShareToken = ""
+}
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(collection.Name != "", "name", "must be provided")
+	v.Check(len(collection.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(collection.Description) <= 1000, "description", "must not be more than 1000 bytes long")
+}
+
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+func (m CollectionModel) Insert(collection *Collection) error {
+	query := `
+		INSERT INTO collections (user_id, name, description, share_token)
+		VALUES ($1, $2, $3, nullif($4, ''))
+		RETURNING id, created_at, version`
+	args := []interface{}{collection.UserID, collection.Name, collection.Description, collection.ShareToken}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.ID, &collection.CreatedAt, &collection.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "collections_user_id_name_key"`:
+			return ErrDuplicateCollection
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m CollectionModel) Get(id int64) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	return m.get("id = $1", id)
+}
+
+func (m CollectionModel) GetByShareToken(shareToken string) (*Collection, error) {
+	return m.get("share_token = $1", shareToken)
+}
+
+func (m CollectionModel) get(condition string, arg interface{}) (*Collection, error) {
+	query := `
+		SELECT id, created_at, user_id, name, description, coalesce(share_token, ''),
+		       (SELECT count(*) FROM collections_games WHERE collection_id = collections.id), version
+		FROM collections
+		WHERE ` + condition
+	var collection Collection
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, arg).Scan(
+		&collection.ID,
+		&collection.CreatedAt,
+		&collection.UserID,
+		&collection.Name,
+		&collection.Description,
+		&collection.ShareToken,
+		&collection.GameCount,
+		&collection.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &collection, nil
+}
+
+func (m CollectionModel) GetAllForUser(userID int64) ([]*Collection, error) {
+	query := `
+		SELECT id, created_at, user_id, name, description, coalesce(share_token, ''),
+		       (SELECT count(*) FROM collections_games WHERE collection_id = collections.id), version
+		FROM collections
+		WHERE user_id = $1
+		ORDER BY name ASC, id ASC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	collections := []*Collection{}
+	for rows.Next() {
+		var collection Collection
+		err := rows.Scan(
+			&collection.ID,
+			&collection.CreatedAt,
+			&collection.UserID,
+			&collection.Name,
+			&collection.Description,
+			&collection.ShareToken,
+			&collection.GameCount,
+			&collection.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, &collection)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+func (m CollectionModel) Update(collection *Collection) error {
+	query := `
+		UPDATE collections
+		SET name = $1, description = $2, share_token = nullif($3, ''), version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+	args := []interface{}{
+		collection.Name,
+		collection.Description,
+		collection.ShareToken,
+		collection.ID,
+		collection.Version,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "collections_user_id_name_key"`:
+			return ErrDuplicateCollection
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m CollectionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM collections
+		WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// AddGame appends a game to the end of a collection. Adding a game which is
+// already in the collection leaves its position unchanged.
+func (m CollectionModel) AddGame(collectionID, gameID int64) error {
+	query := `
+		INSERT INTO collections_games (collection_id, game_id, position)
+		SELECT $1, $2, coalesce(max(position), 0) + 1
+		FROM collections_games
+		WHERE collection_id = $1
+		ON CONFLICT DO NOTHING`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, collectionID, gameID)
+	return err
+}
+
+func (m CollectionModel) RemoveGame(collectionID, gameID int64) error {
+	query := `
+		DELETE FROM collections_games
+		WHERE collection_id = $1 AND game_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, collectionID, gameID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Reorder sets the order of the games in a collection. gameIDs must contain
+// every game in the collection exactly once, otherwise ErrInvalidOrder is
+// returned and the existing order is kept.
+func (m CollectionModel) Reorder(collectionID int64, gameIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var total int
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM collections_games WHERE collection_id = $1`, collectionID).Scan(&total)
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE collections_games
+		SET position = array_position($2::bigint[], game_id)
+		WHERE collection_id = $1 AND game_id = ANY($2)`
+	result, err := tx.ExecContext(ctx, query, collectionID, pq.Array(gameIDs))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if int(rowsAffected) != total || len(gameIDs) != total {
+		return ErrInvalidOrder
+	}
+	return tx.Commit()
+}
+
+func (m CollectionModel) GetGames(collectionID int64) ([]*Game, error) {
+	query := `
+		SELECT ` + gameColumns + `
+		FROM games
+		INNER JOIN collections_games ON collections_games.game_id = games.id
+		WHERE collections_games.collection_id = $1
+		ORDER BY collections_games.position ASC, games.id ASC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	games := []*Game{}
+	for rows.Next() {
+		var game Game
+		err := rows.Scan(game.scanDest()...)
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, &game)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return games, nil
+}
@@ -0,0 +1,96 @@
+package data
+
+import (
+	"EBG.IssataySheg.net/internal/validator"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	ConsentRequested = "requested"
+	ConsentGranted   = "granted"
+	ConsentDeclined  = "declined"
+)
+
+// ConsentEvent is an entry in the audit trail of a guardian's consent to a
+// child's account. Entries are only ever added, never changed.
+type ConsentEvent struct {
+	ID            int64     `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UserID        int64     `json:"-"`
+	Event         string    `json:"event"`
+	GuardianEmail string    `json:"guardian_email"`
+	IPAddress     string    `json:"ip_address,omitempty"`
+	UserAgent     string    `json:"user_agent,omitempty"`
+}
+
+// ValidateGuardian checks that a user younger than age names a guardian to ask
+// for consent, at an address which is not their own.
+func ValidateGuardian(v *validator.Validator, user *User, age int) {
+	if user.NeedsConsent(age) {
+		v.Check(user.GuardianEmail != "", "guardian_email", fmt.Sprintf("must be provided for users under %d", age))
+		validateGuardianEmail(v, user)
+	}
+}
+
+// validateGuardianEmail checks the guardian's address is a valid one and, so
+// that a child cannot consent for themselves, that it is not the user's.
+// Addresses are compared ignoring case, as mail servers do.
+func validateGuardianEmail(v *validator.Validator, user *User) {
+	v.Check(validator.Matches(user.GuardianEmail, validator.EmailRX), "guardian_email", "must be a valid email address")
+	v.Check(!strings.EqualFold(user.GuardianEmail, user.Email), "guardian_email", "must not be the same as email")
+}
+
+type ConsentModel struct {
+	DB *sql.DB
+}
+
+func (m ConsentModel) Insert(event *ConsentEvent) error {
+	query := `
+		INSERT INTO users_consent_events (user_id, event, guardian_email, ip_address, user_agent)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+	args := []interface{}{event.UserID, event.Event, event.GuardianEmail, event.IPAddress, event.UserAgent}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+func (m ConsentModel) GetAllForUser(userID int64) ([]*ConsentEvent, error) {
+	query := `
+		SELECT id, created_at, user_id, event, guardian_email, ip_address, user_agent
+		FROM users_consent_events
+		WHERE user_id = $1
+		ORDER BY id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []*ConsentEvent{}
+	for rows.Next() {
+		var event ConsentEvent
+		err := rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.UserID,
+			&event.Event,
+			&event.GuardianEmail,
+			&event.IPAddress,
+			&event.UserAgent,
+		)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,36 @@
+package data
+
+import (
+	"EBG.IssataySheg.net/internal/validator"
+	"testing"
+	"time"
+)
+
+func TestValidateGuardian(t *testing.T) {
+	child := &Date{time.Now().AddDate(-10, 0, 0)}
+	adult := &Date{time.Now().AddDate(-30, 0, 0)}
+	tests := []struct {
+		name          string
+		dateOfBirth   *Date
+		guardianEmail string
+		valid         bool
+	}{
+		{"child with a guardian", child, "parent@example.com", true},
+		{"child without a guardian", child, "", false},
+		{"child with an invalid address", child, "parent", false},
+		{"child naming themselves", child, "child@example.com", false},
+		{"child naming themselves in capitals", child, "Child@Example.COM", false},
+		{"adult without a guardian", adult, "", true},
+		{"no date of birth", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &User{Email: "child@example.com", DateOfBirth: tt.dateOfBirth, GuardianEmail: tt.guardianEmail}
+			v := validator.New()
+			ValidateGuardian(v, user, 13)
+			if v.Valid() != tt.valid {
+				t.Errorf("got errors %v; want valid %t", v.Errors, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+// Package datatest gives tests which need a real database the one named by the
+// EBG_TEST_DB_DSN environment variable. It must have every migration applied.
+package datatest
+
+import (
+	"database/sql"
+	_ "github.com/lib/pq"
+	"os"
+	"testing"
+)
+
+// NewDB opens the test database, closing it once the test is over. Without
+// EBG_TEST_DB_DSN the test is skipped.
+func NewDB(t testing.TB) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("EBG_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("EBG_TEST_DB_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidDateFormat = errors.New("invalid date format")
+
+const dateLayout = "2006-01-02"
+
+// Date is a calendar day without a time of day, such as a date of birth. It
+// is written in JSON as "2006-01-02" and stored in a date column.
+type Date struct {
+	time.Time
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.Format(dateLayout))), nil
+}
+
+func (d *Date) UnmarshalJSON(jsonValue []byte) error {
+	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+	parsed, err := ParseDate(unquotedJSONValue)
+	if err != nil {
+		return err
+	}
+	*d = *parsed
+	return nil
+}
+
+// ParseDate parses a date written as "2006-01-02".
+func ParseDate(s string) (*Date, error) {
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return nil, ErrInvalidDateFormat
+	}
+	return &Date{t}, nil
+}
+
+func (d *Date) Scan(src interface{}) error {
+	t, ok := src.(time.Time)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into Date", src)
+	}
+	d.Time = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return nil
+}
+
+func (d Date) Value() (driver.Value, error) {
+	return d.Format(dateLayout), nil
+}
+
+// Age returns the number of whole years between the date and now.
+func (d Date) Age(now time.Time) int {
+	age := now.Year() - d.Year()
+	if now.Month() < d.Month() || (now.Month() == d.Month() && now.Day() < d.Day()) {
+		age--
+	}
+	return age
+}
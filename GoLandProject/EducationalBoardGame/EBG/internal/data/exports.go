@@ -0,0 +1,173 @@
+package data
+
+import (
+	"EBG.IssataySheg.net/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	ExportFormatJSON = "json"
+	ExportFormatZIP  = "zip"
+)
+
+const (
+	ExportStatusPending = "pending"
+	ExportStatusReady   = "ready"
+	ExportStatusFailed  = "failed"
+)
+
+var ErrExportInProgress = errors.New("export in progress")
+
+// Export is the request for, and once it is ready the archive of, all the data
+// held about a user. Only the latest export is kept for each user.
+type Export struct {
+	UserID      int64      `json:"user_id"`
+	RequestedBy *int64     `json:"requested_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Format      string     `json:"format"`
+	Status      string     `json:"status"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Size        int        `json:"size,omitempty"`
+}
+
+func ValidateExportFormat(v *validator.Validator, format string) {
+	v.Check(validator.In(format, ExportFormatJSON, ExportFormatZIP), "format", "must be json or zip")
+}
+
+type ExportModel struct {
+	DB *sql.DB
+}
+
+// Start replaces the user's previous export with a new pending one. It returns
+// ErrExportInProgress if an export is already being produced, unless that one
+// has been pending for so long that it must have been lost.
+func (m ExportModel) Start(userID, requestedBy int64, format string) (*Export, error) {
+	query := `
+		INSERT INTO users_exports (user_id, requested_by, format)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET requested_by = EXCLUDED.requested_by, created_at = NOW(), format = EXCLUDED.format,
+		    status = 'pending', completed_at = NULL, expires_at = NULL, archive = NULL
+		WHERE users_exports.status <> 'pending' OR users_exports.created_at < NOW() - INTERVAL '1 hour'
+		RETURNING user_id, requested_by, created_at, format, status`
+	var export Export
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID, requestedBy, format).Scan(
+		&export.UserID,
+		&export.RequestedBy,
+		&export.CreatedAt,
+		&export.Format,
+		&export.Status,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrExportInProgress
+		default:
+			return nil, err
+		}
+	}
+	return &export, nil
+}
+
+// Get returns the user's latest export, as long as it has not expired.
+func (m ExportModel) Get(userID int64) (*Export, error) {
+	query := `
+		SELECT user_id, requested_by, created_at, format, status, completed_at, expires_at, COALESCE(length(archive), 0)
+		FROM users_exports
+		WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > NOW())`
+	var export Export
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&export.UserID,
+		&export.RequestedBy,
+		&export.CreatedAt,
+		&export.Format,
+		&export.Status,
+		&export.CompletedAt,
+		&export.ExpiresAt,
+		&export.Size,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &export, nil
+}
+
+// GetArchive returns the archive of the user's latest export if it is ready.
+func (m ExportModel) GetArchive(userID int64) (*Export, []byte, error) {
+	query := `
+		SELECT user_id, created_at, format, status, completed_at, expires_at, archive
+		FROM users_exports
+		WHERE user_id = $1 AND status = 'ready' AND expires_at > NOW()`
+	var export Export
+	var archive []byte
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&export.UserID,
+		&export.CreatedAt,
+		&export.Format,
+		&export.Status,
+		&export.CompletedAt,
+		&export.ExpiresAt,
+		&archive,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+	export.Size = len(archive)
+	return &export, archive, nil
+}
+
+// Complete stores the archive of a pending export, which can then be
+// downloaded until the ttl runs out.
+func (m ExportModel) Complete(userID int64, archive []byte, ttl time.Duration) error {
+	query := `
+		UPDATE users_exports
+		SET status = 'ready', completed_at = NOW(), expires_at = $2, archive = $3
+		WHERE user_id = $1 AND status = 'pending'`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, time.Now().Add(ttl), archive)
+	return err
+}
+
+func (m ExportModel) Fail(userID int64) error {
+	query := `
+		UPDATE users_exports
+		SET status = 'failed', completed_at = NOW()
+		WHERE user_id = $1 AND status = 'pending'`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// DeleteExpired removes exports whose download window has closed, so that the
+// archives of personal data are not kept once they can no longer be fetched.
+func (m ExportModel) DeleteExpired() error {
+	query := `
+		DELETE FROM users_exports
+		WHERE expires_at < NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query)
+	return err
+}
@@ -0,0 +1,42 @@
+package data
+
+import (
+	"EBG.IssataySheg.net/internal/validator"
+	"fmt"
+)
+
+const scoreBucketSize = 10
+
+var FacetSafelist = []string{"games", "score", "subject", "difficulty", "publisher"}
+
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type Facets map[string][]FacetValue
+
+// facetExpression maps a safelisted facet name onto the SQL expression whose
+// distinct values are counted. Empty strings are turned into NULL so that games
+// without the attribute do not show up as a facet value. Subjects are grouped
+// case-insensitively, as the subject filter matches them.
+func facetExpression(name string) string {
+	switch name {
+	case "games":
+		return "unnest(games)"
+	case "score":
+		return fmt.Sprintf("((score / %[1]d) * %[1]d) || '-' || ((score / %[1]d) * %[1]d + %[2]d)", scoreBucketSize, scoreBucketSize-1)
+	case "subject":
+		return "nullif(lower(subject), '')"
+	case "difficulty", "publisher":
+		return fmt.Sprintf("nullif(%s, '')", name)
+	}
+	panic("unsafe facet parameter: " + name)
+}
+
+func ValidateFacets(v *validator.Validator, names []string) {
+	for _, name := range names {
+		v.Check(validator.In(name, FacetSafelist...), "facets", "invalid facet value")
+	}
+	v.Check(validator.Unique(names), "facets", "must not contain duplicate values")
+}
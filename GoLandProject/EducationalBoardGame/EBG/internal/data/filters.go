@@ -2,15 +2,79 @@ package data
 
 import (
 	"EBG.IssataySheg.net/internal/validator"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
+	"strconv"
 	"strings"
 )
 
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	SortTypes    map[string]string
+	Cursor       string
+}
+
+// Types a sort column can have, for checking the sort value held in a cursor.
+// A column missing from Filters.SortTypes is text.
+const (
+	SortInteger = "integer"
+	SortFloat   = "float"
+)
+
+// cursor is the decoded form of the opaque keyset pagination cursor. It records
+// the sort it was produced for, the sort column value and id of the boundary
+// row, and whether it points backwards from that row.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"i"`
+	Prev  bool   `json:"p,omitempty"`
+}
+
+func (c cursor) encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.ID < 1 {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// value parses the cursor's sort value as the given column type, so that a
+// tampered cursor is rejected rather than failing the query.
+func (c cursor) value(sortType string) (interface{}, error) {
+	switch sortType {
+	case SortInteger:
+		n, err := strconv.ParseInt(c.Value, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		return n, nil
+	case SortFloat:
+		n, err := strconv.ParseFloat(c.Value, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, ErrInvalidCursor
+		}
+		return n, nil
+	}
+	return c.Value, nil
 }
 
 func (f Filters) sortColumn() string {
@@ -36,20 +100,64 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+func (f Filters) usesCursor() bool {
+	return f.Cursor != ""
+}
+
+// keyset returns the condition restricting a query to the rows after (or, for
+// a backwards cursor, before) the cursor's boundary row, the ORDER BY clause to
+// fetch them in, and whether the fetched rows must be reversed afterwards. The
+// sort value and id are bound to placeholders $n and $n+1.
+func (f Filters) keyset(expr string, n int) (string, string, []interface{}, bool, error) {
+	c, err := decodeCursor(f.Cursor)
+	if err != nil {
+		return "", "", nil, false, err
+	}
+	value, err := c.value(f.SortTypes[f.sortColumn()])
+	if err != nil {
+		return "", "", nil, false, err
+	}
+	ascending := f.sortDirection() == "ASC"
+	if c.Prev {
+		ascending = !ascending
+	}
+	valueOp, idOp, direction, idDirection := ">", ">", "ASC", "ASC"
+	if !ascending {
+		valueOp, direction = "<", "DESC"
+	}
+	if c.Prev {
+		idOp, idDirection = "<", "DESC"
+	}
+	condition := fmt.Sprintf("AND (%[1]s %[2]s $%[4]d OR (%[1]s = $%[4]d AND id %[3]s $%[5]d))", expr, valueOp, idOp, n, n+1)
+	order := fmt.Sprintf("%s %s, id %s", expr, direction, idDirection)
+	return condition, order, []interface{}{value, c.ID}, c.Prev, nil
+}
+
 func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page > 0, "page", "must be greater than zero")
 	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	if f.usesCursor() {
+		c, err := decodeCursor(f.Cursor)
+		v.Check(err == nil, "cursor", "invalid cursor")
+		v.Check(err != nil || c.Sort == f.Sort, "cursor", "does not match the sort value")
+		if err == nil {
+			_, err = c.value(f.SortTypes[strings.TrimPrefix(f.Sort, "-")])
+			v.Check(err == nil, "cursor", "invalid cursor")
+		}
+	}
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -65,3 +173,19 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 		TotalRecords: totalRecords,
 	}
 }
+
+// calculateCursors fills in the cursors pointing at the pages either side of
+// the current one, whose first and last rows have the given sort keys and ids.
+// hasPrev and hasNext report whether there are any rows in those directions.
+func calculateCursors(metadata *Metadata, sort string, keys []string, ids []int64, hasPrev, hasNext bool) {
+	if len(ids) == 0 {
+		return
+	}
+	if hasPrev {
+		metadata.PrevCursor = cursor{Sort: sort, Value: keys[0], ID: ids[0], Prev: true}.encode()
+	}
+	if hasNext {
+		last := len(ids) - 1
+		metadata.NextCursor = cursor{Sort: sort, Value: keys[last], ID: ids[last]}.encode()
+	}
+}
@@ -7,28 +7,180 @@ import (
 	"errors"
 	"fmt"
 	"github.com/lib/pq"
+	"strings"
 	"time"
 )
 
+var (
+	DifficultyLevels = []string{"easy", "medium", "hard"}
+	SearchModes      = []string{"fulltext", "fuzzy"}
+)
+
 type Game struct {
-	ID          int64     `json:"id"`
-	CreatedAt   time.Time `json:"-"`
-	Title       string    `json:"title"`
-	Description string    `json:"description,omitempty"`
-	Games       []string  `json:"games,omitempty"`
-	Score       Score     `json:"score,omitempty"`
-	Version     int32     `json:"version"`
+	ID            int64     `json:"id"`
+	CreatedAt     time.Time `json:"-"`
+	Title         string    `json:"title"`
+	Description   string    `json:"description,omitempty"`
+	Games         []string  `json:"games,omitempty"`
+	Score         Score     `json:"score,omitempty"`
+	MinPlayers    int32     `json:"min_players"`
+	MaxPlayers    int32     `json:"max_players"`
+	MinAge        int32     `json:"min_age"`
+	MaxAge        int32     `json:"max_age,omitempty"`
+	PlayTime      int32     `json:"play_time"`
+	Subject       string    `json:"subject,omitempty"`
+	Difficulty    string    `json:"difficulty,omitempty"`
+	Publisher     string    `json:"publisher,omitempty"`
+	Year          int32     `json:"year,omitempty"`
+	AverageRating float64   `json:"average_rating"`
+	ReviewCount   int32     `json:"review_count"`
+	Version       int32     `json:"version"`
 }
 
-func ValidateMovie(v *validator.Validator, game *Game) {
+const (
+	gameAverageRating = "(SELECT coalesce(round(avg(rating), 2), 0)::float8 FROM reviews WHERE reviews.game_id = games.id)"
+	gameReviewCount   = "(SELECT count(*) FROM reviews WHERE reviews.game_id = games.id)"
+)
+
+// gameColumns lists the columns selected for a Game, in the order expected by
+// Game.scanDest.
+var gameColumns = `games.id, games.created_at, games.title, games.description, games.score, games.games,
+		games.min_players, games.max_players, games.min_age, games.max_age, games.play_time, games.subject,
+		games.difficulty, games.publisher, games.year, ` + gameAverageRating + `, ` + gameReviewCount + `, games.version`
+
+func (game *Game) scanDest() []interface{} {
+	return []interface{}{
+		&game.ID,
+		&game.CreatedAt,
+		&game.Title,
+		&game.Description,
+		&game.Score,
+		pq.Array(&game.Games),
+		&game.MinPlayers,
+		&game.MaxPlayers,
+		&game.MinAge,
+		&game.MaxAge,
+		&game.PlayTime,
+		&game.Subject,
+		&game.Difficulty,
+		&game.Publisher,
+		&game.Year,
+		&game.AverageRating,
+		&game.ReviewCount,
+		&game.Version,
+	}
+}
+
+// ValidateNewGame checks a game about to be added to the catalogue. New games
+// must give the player counts and play time, which ValidateGame lets be 0 for
+// games added before they were recorded.
+func ValidateNewGame(v *validator.Validator, game *Game) {
+	v.Check(game.MinPlayers != 0, "min_players", "must be provided")
+	v.Check(game.MaxPlayers != 0, "max_players", "must be provided")
+	v.Check(game.PlayTime != 0, "play_time", "must be provided")
+	ValidateGame(v, game)
+}
+
+// ValidateGame checks a game's fields. A player count or play time of 0 means
+// it is unknown, as the games table's constraints allow.
+func ValidateGame(v *validator.Validator, game *Game) {
 	v.Check(game.Title != "", "title", "must be provided")
 	v.Check(len(game.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(len(game.Description) <= 5000, "description", "must not be more than 5000 bytes long")
 	v.Check(game.Score != 0, "score", "must be provided")
 	v.Check(game.Score > 0, "score", "must be a positive integer")
 	v.Check(game.Games != nil, "games", "must be provided")
 	v.Check(len(game.Games) >= 1, "games", "must contain at least 1 genre")
 	v.Check(len(game.Games) <= 5, "games", "must not contain more than 5 genres")
 	v.Check(validator.Unique(game.Games), "games", "must not contain duplicate values")
+	v.Check(game.MinPlayers >= 0, "min_players", "must not be negative")
+	v.Check(game.MaxPlayers >= game.MinPlayers, "max_players", "must not be less than min_players")
+	v.Check(game.MaxPlayers <= 100, "max_players", "must not be more than 100")
+	v.Check(game.MinAge >= 0, "min_age", "must not be negative")
+	v.Check(game.MinAge <= 99, "min_age", "must not be more than 99")
+	v.Check(game.MaxAge == 0 || game.MaxAge >= game.MinAge, "max_age", "must not be less than min_age")
+	v.Check(game.MaxAge <= 99, "max_age", "must not be more than 99")
+	v.Check(game.PlayTime >= 0, "play_time", "must not be negative")
+	v.Check(game.PlayTime <= 1440, "play_time", "must not be more than 1440 minutes")
+	v.Check(len(game.Subject) <= 100, "subject", "must not be more than 100 bytes long")
+	v.Check(game.Difficulty == "" || validator.In(game.Difficulty, DifficultyLevels...), "difficulty", "must be one of easy, medium or hard")
+	v.Check(len(game.Publisher) <= 500, "publisher", "must not be more than 500 bytes long")
+	v.Check(game.Year == 0 || game.Year >= 1800, "year", "must not be before 1800")
+	v.Check(game.Year <= int32(time.Now().Year()), "year", "must not be in the future")
+}
+
+type GameQuery struct {
+	Title       string
+	Search      string
+	Games       []string
+	Players     int
+	Age         int
+	MaxPlayTime int
+	Subject     string
+	Difficulty  string
+}
+
+// where returns the WHERE clause shared by the listing and facet queries
+// together with its arguments, which always occupy placeholders $1 to $7.
+func (q GameQuery) where() (string, []interface{}) {
+	match := "to_tsvector('simple', title) @@ plainto_tsquery('simple', $1)"
+	if q.Search == "fuzzy" {
+		// The trigram operators tolerate typos, and word similarity lets a
+		// partial word such as "chem" match "Chemistry Quest".
+		match = "(title % $1 OR $1 <% title)"
+	}
+	clause := `
+			WHERE (` + match + ` OR $1 = '')
+			AND (games @> $2 OR $2 = '{}')
+			AND ((min_players <= $3 AND max_players >= $3) OR $3 = 0)
+			AND ((min_age <= $4 AND (max_age = 0 OR max_age >= $4)) OR $4 = 0)
+			AND ((play_time > 0 AND play_time <= $5) OR $5 = 0)
+			AND (lower(subject) = lower($6) OR $6 = '')
+			AND (difficulty = $7 OR $7 = '')`
+	args := []interface{}{
+		q.Title,
+		pq.Array(q.Games),
+		q.Players,
+		q.Age,
+		q.MaxPlayTime,
+		q.Subject,
+		q.Difficulty,
+	}
+	return clause, args
+}
+
+// GameSortTypes gives the type of each game sort column that is not text.
+var GameSortTypes = map[string]string{
+	"id":        SortInteger,
+	"score":     SortInteger,
+	"play_time": SortInteger,
+	"year":      SortInteger,
+	"rating":    SortFloat,
+	"reviews":   SortInteger,
+	"relevance": SortFloat,
+}
+
+// gameSortExpression maps a safelisted sort column onto the SQL expression that
+// games are ordered by. Relevance is negated so that the most similar titles
+// come first in ascending order, which keeps it working with keyset cursors.
+func gameSortExpression(column string) string {
+	switch column {
+	case "relevance":
+		return "-greatest(similarity(title, $1), word_similarity($1, title))"
+	case "rating":
+		return gameAverageRating
+	case "reviews":
+		return gameReviewCount
+	}
+	return column
+}
+
+func ValidateGameQuery(v *validator.Validator, q GameQuery) {
+	v.Check(validator.In(q.Search, SearchModes...), "search", "must be one of fulltext or fuzzy")
+	v.Check(q.Players >= 0, "players", "must not be negative")
+	v.Check(q.Age >= 0, "age", "must not be negative")
+	v.Check(q.MaxPlayTime >= 0, "max_play_time", "must not be negative")
+	v.Check(q.Difficulty == "" || validator.In(q.Difficulty, DifficultyLevels...), "difficulty", "must be one of easy, medium or hard")
 }
 
 type GameModel struct {
@@ -37,10 +189,24 @@ type GameModel struct {
 
 func (m GameModel) Insert(game *Game) error {
 	query := `
-		INSERT INTO games (title, score, games)
-		VALUES ($1, $2, $3)
+		INSERT INTO games (title, description, score, games, min_players, max_players, min_age, max_age, play_time, subject, difficulty, publisher, year)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 		RETURNING id, created_at, version`
-	args := []interface{}{game.Title, game.Score, pq.Array(game.Games)}
+	args := []interface{}{
+		game.Title,
+		game.Description,
+		game.Score,
+		pq.Array(game.Games),
+		game.MinPlayers,
+		game.MaxPlayers,
+		game.MinAge,
+		game.MaxAge,
+		game.PlayTime,
+		game.Subject,
+		game.Difficulty,
+		game.Publisher,
+		game.Year,
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&game.ID, &game.CreatedAt, &game.Version)
@@ -51,21 +217,14 @@ func (m GameModel) Get(id int64) (*Game, error) {
 		return nil, ErrRecordNotFound
 	}
 	query := `
-		SELECT  , id, created_at, title, score, games, version
+		SELECT ` + gameColumns + `
 		FROM games
 		WHERE id = $1`
 	var game Game
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&game.ID,
-		&game.CreatedAt,
-		&game.Title,
-		&game.Score,
-		pq.Array(&game.Games),
-		&game.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(game.scanDest()...)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -80,13 +239,24 @@ func (m GameModel) Get(id int64) (*Game, error) {
 func (m GameModel) Update(game *Game) error {
 	query := `
 		UPDATE games
-		SET title = $1, score = $2, games = $3, version = version + 1
-		WHERE id = $4 AND version = $5
+		SET title = $1, description = $2, score = $3, games = $4, min_players = $5, max_players = $6, min_age = $7,
+		    max_age = $8, play_time = $9, subject = $10, difficulty = $11, publisher = $12, year = $13, version = version + 1
+		WHERE id = $14 AND version = $15
 		RETURNING version`
 	args := []interface{}{
 		game.Title,
+		game.Description,
 		game.Score,
 		pq.Array(game.Games),
+		game.MinPlayers,
+		game.MaxPlayers,
+		game.MinAge,
+		game.MaxAge,
+		game.PlayTime,
+		game.Subject,
+		game.Difficulty,
+		game.Publisher,
+		game.Year,
 		game.ID,
 		game.Version,
 	}
@@ -129,17 +299,33 @@ func (m GameModel) Delete(id int64) error { // Return an ErrRecordNotFound error
 	return nil
 }
 
-func (m GameModel) GetAll(title string, Games []string, filters Filters) ([]*Game, Metadata, error) {
+func (m GameModel) GetAll(q GameQuery, filters Filters) ([]*Game, Metadata, error) {
+	where, args := q.where()
+	column := gameSortExpression(filters.sortColumn())
+	order := fmt.Sprintf("%s %s, id ASC", column, filters.sortDirection())
+	paging := "LIMIT $8 OFFSET $9"
+	reverse := false
+	if filters.usesCursor() {
+		condition, keysetOrder, keysetArgs, prev, err := filters.keyset(column, 9)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		where += "\n			" + condition
+		order, reverse = keysetOrder, prev
+		paging = "LIMIT $8"
+		// Fetch one extra row to find out whether there is another page.
+		args = append(args, filters.limit()+1)
+		args = append(args, keysetArgs...)
+	} else {
+		args = append(args, filters.limit(), filters.offset())
+	}
 	query := fmt.Sprintf(`
-			SELECT count(*) OVER(), id, created_at, title, score, games, version
-			FROM games
-			WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-			AND (games @> $2 OR $2 = '{}')
-			ORDER BY %s %s, id ASC
-			LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+			SELECT count(*) OVER(), %s, (%s)::text
+			FROM games %s
+			ORDER BY %s
+			%s`, gameColumns, column, where, order, paging)
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	args := []interface{}{title, pq.Array(Games), filters.limit(), filters.offset()}
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
@@ -147,27 +333,86 @@ func (m GameModel) GetAll(title string, Games []string, filters Filters) ([]*Gam
 	defer rows.Close()
 	totalRecords := 0
 	games := []*Game{}
+	var keys []string
+	var ids []int64
 	for rows.Next() {
 		var game Game
-		err := rows.Scan(
-			&totalRecords,
-			&game.ID,
-			&game.CreatedAt,
-			&game.Title,
-			&game.Score,
-			pq.Array(&game.Games),
-			&game.Version,
-		)
+		var key string
+		dest := append([]interface{}{&totalRecords}, game.scanDest()...)
+		err := rows.Scan(append(dest, &key)...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
 		games = append(games, &game)
+		keys = append(keys, key)
+		ids = append(ids, game.ID)
 	}
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
+	if filters.usesCursor() {
+		hasMore := len(games) > filters.limit()
+		if hasMore {
+			games, keys, ids = games[:filters.limit()], keys[:filters.limit()], ids[:filters.limit()]
+		}
+		if reverse {
+			for i, j := 0, len(games)-1; i < j; i, j = i+1, j-1 {
+				games[i], games[j] = games[j], games[i]
+				keys[i], keys[j] = keys[j], keys[i]
+				ids[i], ids[j] = ids[j], ids[i]
+			}
+		}
+		metadata := Metadata{PageSize: filters.PageSize}
+		// A forward cursor was produced from an earlier page, so there are
+		// always rows before this one; the same holds in reverse.
+		calculateCursors(&metadata, filters.Sort, keys, ids, !reverse || hasMore, reverse || hasMore)
+		return games, metadata, nil
+	}
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	calculateCursors(&metadata, filters.Sort, keys, ids, filters.Page > 1, filters.Page*filters.PageSize < totalRecords)
 	return games, metadata, nil
+}
 
+// Facets counts the games matching q for each of the requested facets. Every
+// requested facet is present in the result, even when no game matches.
+func (m GameModel) Facets(q GameQuery, names []string) (Facets, error) {
+	facets := Facets{}
+	if len(names) == 0 {
+		return facets, nil
+	}
+	var selects []string
+	for _, name := range names {
+		facets[name] = []FacetValue{}
+		selects = append(selects, fmt.Sprintf(`
+			SELECT '%s', value, count(*)
+			FROM (SELECT %s AS value FROM matched) AS f
+			WHERE value IS NOT NULL
+			GROUP BY value`, name, facetExpression(name)))
+	}
+	where, args := q.where()
+	query := fmt.Sprintf(`
+			WITH matched AS (SELECT games, score, subject, difficulty, publisher FROM games %s)
+			%s
+			ORDER BY 1, 3 DESC, 2`, where, strings.Join(selects, "\n			UNION ALL"))
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var value FacetValue
+		err := rows.Scan(&name, &value.Value, &value.Count)
+		if err != nil {
+			return nil, err
+		}
+		facets[name] = append(facets[name], value)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return facets, nil
 }
@@ -0,0 +1,66 @@
+package data
+
+import (
+	"EBG.IssataySheg.net/internal/validator"
+	"testing"
+)
+
+func TestValidateGame(t *testing.T) {
+	// legacy is a game added before player counts and play time were
+	// recorded, as migration 000006 left it.
+	legacy := func() *Game {
+		return &Game{Title: "Chess", Score: 10, Games: []string{"strategy"}}
+	}
+	tests := []struct {
+		name     string
+		game     func() *Game
+		valid    bool
+		newValid bool
+	}{
+		{"unknown players and play time", legacy, true, false},
+		{"complete", func() *Game {
+			g := legacy()
+			g.MinPlayers, g.MaxPlayers, g.PlayTime = 2, 2, 30
+			return g
+		}, true, true},
+		{"negative players", func() *Game {
+			g := legacy()
+			g.MinPlayers = -1
+			return g
+		}, false, false},
+		{"max players below min players", func() *Game {
+			g := legacy()
+			g.MinPlayers, g.MaxPlayers, g.PlayTime = 3, 2, 30
+			return g
+		}, false, false},
+		{"negative play time", func() *Game {
+			g := legacy()
+			g.PlayTime = -1
+			return g
+		}, false, false},
+		{"year 1800", func() *Game {
+			g := legacy()
+			g.Year = 1800
+			return g
+		}, true, false},
+		{"year before 1800", func() *Game {
+			g := legacy()
+			g.Year = 1799
+			return g
+		}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateGame(v, tt.game())
+			if v.Valid() != tt.valid {
+				t.Errorf("ValidateGame: got errors %v; want valid %t", v.Errors, tt.valid)
+			}
+			v = validator.New()
+			ValidateNewGame(v, tt.game())
+			if v.Valid() != tt.newValid {
+				t.Errorf("ValidateNewGame: got errors %v; want valid %t", v.Errors, tt.newValid)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Identity links a user to their account with an external OpenID provider,
+// which knows them by an opaque subject identifier.
+type Identity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"-"`
+	UserID    int64     `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	Email     string    `json:"email"`
+}
+
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// Insert links the identity to its user. Linking an identity again is not an
+// error.
+func (m IdentityModel) Insert(identity *Identity) error {
+	query := `
+		INSERT INTO users_identities (provider, subject, user_id, email)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (provider, subject) DO NOTHING`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, identity.Provider, identity.Subject, identity.UserID, identity.Email)
+	return err
+}
+
+// GetUser returns the user the provider's subject is linked to.
+func (m IdentityModel) GetUser(provider, subject string) (*User, error) {
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version,
+		       users.date_of_birth, users.guardian_email, users.consented_at
+		FROM users
+		INNER JOIN users_identities ON users_identities.user_id = users.id
+		WHERE users_identities.provider = $1 AND users_identities.subject = $2`
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.DateOfBirth,
+		&user.GuardianEmail,
+		&user.ConsentedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+func (m IdentityModel) GetAllForUser(userID int64) ([]*Identity, error) {
+	query := `
+		SELECT provider, subject, user_id, created_at, email
+		FROM users_identities
+		WHERE user_id = $1
+		ORDER BY provider`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	identities := []*Identity{}
+	for rows.Next() {
+		var identity Identity
+		err := rows.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.CreatedAt, &identity.Email)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, &identity)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// OIDCLogin is a sign-in with an OpenID provider that has been started but not
+// yet finished. It is found again by the state the provider hands back, and
+// holds the nonce and PKCE code verifier needed to finish it.
+type OIDCLogin struct {
+	Provider     string
+	State        string
+	Nonce        string
+	CodeVerifier string
+	Expiry       time.Time
+}
+
+type OIDCLoginModel struct {
+	DB *sql.DB
+}
+
+func (m OIDCLoginModel) Insert(login *OIDCLogin) error {
+	query := `
+		INSERT INTO oidc_logins (state_hash, provider, nonce, code_verifier, expiry)
+		VALUES ($1, $2, $3, $4, $5)`
+	args := []interface{}{HashToken(login.State), login.Provider, login.Nonce, login.CodeVerifier, login.Expiry}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Take returns and deletes the unexpired login for the state, so that each
+// login can only be finished once. Expired logins are cleared out on the way.
+func (m OIDCLoginModel) Take(provider, state string) (*OIDCLogin, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expiry < NOW()`)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+		DELETE FROM oidc_logins
+		WHERE state_hash = $1 AND provider = $2
+		RETURNING provider, nonce, code_verifier, expiry`
+	login := OIDCLogin{State: state}
+	err = m.DB.QueryRowContext(ctx, query, HashToken(state), provider).Scan(
+		&login.Provider,
+		&login.Nonce,
+		&login.CodeVerifier,
+		&login.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &login, nil
+}
@@ -11,17 +11,47 @@ var (
 )
 
 type Models struct {
-	Games       GameModel
-	Permissions PermissionModel
-	Users       UserModel
-	Tokens      TokenModel
+	APIKeys      APIKeyModel
+	Classrooms   ClassroomModel
+	Collections  CollectionModel
+	Consents     ConsentModel
+	Exports      ExportModel
+	Games        GameModel
+	Identities   IdentityModel
+	OAuthClients OAuthClientModel
+	OAuthTokens  OAuthTokenModel
+	OIDCLogins   OIDCLoginModel
+	Permissions  PermissionModel
+	Questions    QuestionModel
+	Reviews      ReviewModel
+	Roles        RoleModel
+	Sessions     SessionModel
+	Throttles    LoginThrottleModel
+	TOTP         TOTPModel
+	Users        UserModel
+	Tokens       TokenModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Games:       GameModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		APIKeys:      APIKeyModel{DB: db},
+		Classrooms:   ClassroomModel{DB: db},
+		Collections:  CollectionModel{DB: db},
+		Consents:     ConsentModel{DB: db},
+		Exports:      ExportModel{DB: db},
+		Games:        GameModel{DB: db},
+		Identities:   IdentityModel{DB: db},
+		OAuthClients: OAuthClientModel{DB: db},
+		OAuthTokens:  OAuthTokenModel{DB: db},
+		OIDCLogins:   OIDCLoginModel{DB: db},
+		Permissions:  PermissionModel{DB: db},
+		Questions:    QuestionModel{DB: db},
+		Reviews:      ReviewModel{DB: db},
+		Roles:        RoleModel{DB: db},
+		Sessions:     SessionModel{DB: db},
+		Throttles:    LoginThrottleModel{DB: db},
+		Tokens:       TokenModel{DB: db},
+		TOTP:         TOTPModel{DB: db},
+		Users:        UserModel{DB: db},
 	}
 }
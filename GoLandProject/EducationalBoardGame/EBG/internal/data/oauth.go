@@ -0,0 +1,577 @@
+package data
+
+import (
+	"EBG.IssataySheg.net/internal/validator"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"github.com/lib/pq"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OAuthAccessTokenPrefix and OAuthRefreshTokenPrefix start the tokens issued
+// to OAuth2 clients, which is how authenticate tells access tokens apart from
+// our own authentication tokens and API keys.
+const (
+	OAuthAccessTokenPrefix  = "ebgo_"
+	OAuthRefreshTokenPrefix = "ebgr_"
+)
+
+const (
+	oauthKindAccess  = "access"
+	oauthKindRefresh = "refresh"
+)
+
+// OAuthClient is a third-party app registered to act for users through the
+// OAuth2 authorization code flow. Clients without a secret are public, such
+// as single-page and mobile apps; PKCE is required of every client. The
+// secret is only available when the client is registered.
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UserID       int64     `json:"-"`
+	Name         string    `json:"name"`
+	Secret       string    `json:"client_secret,omitempty"`
+	SecretHash   []byte    `json:"-"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Public       bool      `json:"public"`
+}
+
+// CheckSecret reports whether secret is the client's secret. Public clients
+// have none, so only an empty secret matches.
+func (c *OAuthClient) CheckSecret(secret string) bool {
+	if c.SecretHash == nil {
+		return secret == ""
+	}
+	return subtle.ConstantTimeCompare(HashToken(secret), c.SecretHash) == 1
+}
+
+// AllowsRedirect reports whether uri is exactly one of the client's
+// registered redirect URIs.
+func (c *OAuthClient) AllowsRedirect(uri string) bool {
+	for _, allowed := range c.RedirectURIs {
+		if uri == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *OAuthClient) AllowsScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		if !Permissions(c.Scopes).Include(scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func ValidateOAuthClient(v *validator.Validator, client *OAuthClient) {
+	v.Check(client.Name != "", "name", "must be provided")
+	v.Check(len(client.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(client.RedirectURIs) > 0, "redirect_uris", "must contain at least 1 URI")
+	v.Check(len(client.RedirectURIs) <= 10, "redirect_uris", "must not contain more than 10 URIs")
+	v.Check(validator.Unique(client.RedirectURIs), "redirect_uris", "must not contain duplicate values")
+	for _, uri := range client.RedirectURIs {
+		v.Check(validRedirectURI(uri), "redirect_uris", "must be absolute https URIs without a fragment, or http on localhost")
+	}
+	v.Check(len(client.Scopes) > 0, "scopes", "must contain at least 1 permission code")
+	v.Check(validator.Unique(client.Scopes), "scopes", "must not contain duplicate values")
+}
+
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" || u.Fragment != "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
+
+// ParseOAuthScope splits a space separated OAuth2 scope parameter.
+func ParseOAuthScope(scope string) []string {
+	return strings.Fields(scope)
+}
+
+// OAuthCode is an authorization code, which a client exchanges for tokens
+// together with the PKCE code verifier behind CodeChallenge.
+type OAuthCode struct {
+	Plaintext     string
+	ClientID      string
+	UserID        int64
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string
+	Expiry        time.Time
+}
+
+// OAuthToken is an access or refresh token issued to a client. An access
+// token grants the client only the permission codes in Scopes, and only while
+// the user still holds them.
+type OAuthToken struct {
+	ClientID string
+	UserID   int64
+	Scopes   []string
+	Expiry   time.Time
+}
+
+func (t *OAuthToken) Permissions(owner Permissions) Permissions {
+	return owner.Restrict(t.Scopes)
+}
+
+type OAuthTokenPair struct {
+	UserID       int64
+	AccessToken  string
+	RefreshToken string
+	Scopes       []string
+	ExpiresIn    int
+}
+
+// OAuthAuthorization is a client which a user has given access to their
+// account, and which still holds tokens for it.
+type OAuthAuthorization struct {
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func ValidateOAuthTokenPlaintext(v *validator.Validator, prefix, plaintext string) {
+	v.Check(strings.HasPrefix(plaintext, prefix), "token", "must start with "+prefix)
+	v.Check(len(plaintext) == len(prefix)+32, "token", "must be 37 bytes long")
+}
+
+func randomString(prefix string, n int) (string, error) {
+	randomBytes := make([]byte, n)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return prefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)), nil
+}
+
+type OAuthClientModel struct {
+	DB *sql.DB
+}
+
+// Insert registers the client, giving it an ID and, unless it is public, a
+// secret.
+func (m OAuthClientModel) Insert(client *OAuthClient) error {
+	var err error
+	client.ID, err = randomString("", 10)
+	if err != nil {
+		return err
+	}
+	if !client.Public {
+		client.Secret, err = randomString("", 20)
+		if err != nil {
+			return err
+		}
+		client.SecretHash = HashToken(client.Secret)
+	}
+	query := `
+		INSERT INTO oauth_clients (id, user_id, name, secret_hash, redirect_uris, scopes)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`
+	args := []interface{}{client.ID, client.UserID, client.Name, client.SecretHash, pq.Array(client.RedirectURIs), pq.Array(client.Scopes)}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&client.CreatedAt)
+}
+
+func (m OAuthClientModel) Get(id string) (*OAuthClient, error) {
+	query := `
+		SELECT id, created_at, user_id, name, secret_hash, redirect_uris, scopes
+		FROM oauth_clients
+		WHERE id = $1`
+	var client OAuthClient
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&client.ID,
+		&client.CreatedAt,
+		&client.UserID,
+		&client.Name,
+		&client.SecretHash,
+		pq.Array(&client.RedirectURIs),
+		pq.Array(&client.Scopes),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	client.Public = client.SecretHash == nil
+	return &client, nil
+}
+
+func (m OAuthClientModel) GetAllForUser(userID int64) ([]*OAuthClient, error) {
+	query := `
+		SELECT id, created_at, user_id, name, secret_hash, redirect_uris, scopes
+		FROM oauth_clients
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	clients := []*OAuthClient{}
+	for rows.Next() {
+		var client OAuthClient
+		err := rows.Scan(
+			&client.ID,
+			&client.CreatedAt,
+			&client.UserID,
+			&client.Name,
+			&client.SecretHash,
+			pq.Array(&client.RedirectURIs),
+			pq.Array(&client.Scopes),
+		)
+		if err != nil {
+			return nil, err
+		}
+		client.Public = client.SecretHash == nil
+		clients = append(clients, &client)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// Delete removes the client along with every code and token issued to it.
+func (m OAuthClientModel) Delete(userID int64, id string) error {
+	query := `
+		DELETE FROM oauth_clients
+		WHERE user_id = $1 AND id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+type OAuthTokenModel struct {
+	DB *sql.DB
+}
+
+// NewCode stores an authorization code, filling in its plaintext.
+func (m OAuthTokenModel) NewCode(code *OAuthCode) error {
+	var err error
+	code.Plaintext, err = randomString("", 20)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO oauth_codes (hash, client_id, user_id, redirect_uri, scopes, code_challenge, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	args := []interface{}{
+		HashToken(code.Plaintext),
+		code.ClientID,
+		code.UserID,
+		code.RedirectURI,
+		pq.Array(code.Scopes),
+		code.CodeChallenge,
+		code.Expiry,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// TakeCode returns and deletes the client's unexpired code, so that each code
+// can only be exchanged once.
+func (m OAuthTokenModel) TakeCode(clientID, plaintext string) (*OAuthCode, error) {
+	query := `
+		DELETE FROM oauth_codes
+		WHERE hash = $1 AND client_id = $2
+		RETURNING client_id, user_id, redirect_uri, scopes, code_challenge, expiry`
+	code := OAuthCode{Plaintext: plaintext}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, HashToken(plaintext), clientID).Scan(
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		pq.Array(&code.Scopes),
+		&code.CodeChallenge,
+		&code.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if time.Now().After(code.Expiry) {
+		return nil, ErrRecordNotFound
+	}
+	return &code, nil
+}
+
+// NewPair issues an access token and a refresh token to the client, starting
+// a new token family for the grant.
+func (m OAuthTokenModel) NewPair(clientID string, userID int64, scopes []string, accessTTL, refreshTTL time.Duration) (*OAuthTokenPair, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var family int64
+	err = tx.QueryRowContext(ctx, `SELECT nextval('oauth_tokens_family_seq')`).Scan(&family)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := m.insertPair(ctx, tx, clientID, userID, family, scopes, accessTTL, refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+	return pair, tx.Commit()
+}
+
+// Rotate exchanges the client's refresh token for a new pair in the same
+// family, revoking the access tokens issued earlier in it. As with sign-in
+// tokens, the old refresh token is marked as used rather than deleted, so that
+// a later attempt to use it again is detected; that revokes the whole family
+// and returns ErrTokenReused.
+func (m OAuthTokenModel) Rotate(clientID, refreshPlaintext string, accessTTL, refreshTTL time.Duration) (*OAuthTokenPair, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	query := `
+		SELECT user_id, scopes, family, used_at
+		FROM oauth_tokens
+		WHERE hash = $1 AND client_id = $2 AND kind = $3 AND expiry > $4
+		FOR UPDATE`
+	var (
+		userID int64
+		scopes []string
+		family int64
+		usedAt sql.NullTime
+	)
+	hash := HashToken(refreshPlaintext)
+	err = tx.QueryRowContext(ctx, query, hash, clientID, oauthKindRefresh, time.Now()).Scan(&userID, pq.Array(&scopes), &family, &usedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if usedAt.Valid {
+		_, err = tx.ExecContext(ctx, `DELETE FROM oauth_tokens WHERE family = $1`, family)
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrTokenReused
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE oauth_tokens SET used_at = NOW() WHERE hash = $1`, hash)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM oauth_tokens WHERE family = $1 AND kind = $2`, family, oauthKindAccess)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := m.insertPair(ctx, tx, clientID, userID, family, scopes, accessTTL, refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+	return pair, tx.Commit()
+}
+
+func (m OAuthTokenModel) insertPair(ctx context.Context, tx *sql.Tx, clientID string, userID, family int64, scopes []string, accessTTL, refreshTTL time.Duration) (*OAuthTokenPair, error) {
+	access, err := randomString(OAuthAccessTokenPrefix, 20)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomString(OAuthRefreshTokenPrefix, 20)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+		INSERT INTO oauth_tokens (hash, client_id, user_id, kind, scopes, expiry, family)
+		VALUES ($1, $2, $3, $4, $5, $6, $7), ($8, $2, $3, $9, $5, $10, $7)`
+	now := time.Now()
+	args := []interface{}{
+		HashToken(access), clientID, userID, oauthKindAccess, pq.Array(scopes), now.Add(accessTTL), family,
+		HashToken(refresh), oauthKindRefresh, now.Add(refreshTTL),
+	}
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &OAuthTokenPair{
+		UserID:       userID,
+		AccessToken:  access,
+		RefreshToken: refresh,
+		Scopes:       scopes,
+		ExpiresIn:    int(accessTTL.Seconds()),
+	}, nil
+}
+
+// GetForAccessToken returns an unexpired access token along with its user.
+func (m OAuthTokenModel) GetForAccessToken(plaintext string) (*OAuthToken, *User, error) {
+	query := `
+		SELECT oauth_tokens.client_id, oauth_tokens.user_id, oauth_tokens.scopes, oauth_tokens.expiry,
+		       users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version,
+		       users.date_of_birth, users.guardian_email, users.consented_at
+		FROM oauth_tokens
+		INNER JOIN users ON users.id = oauth_tokens.user_id
+		WHERE oauth_tokens.hash = $1 AND oauth_tokens.kind = $2 AND oauth_tokens.expiry > $3`
+	var (
+		token OAuthToken
+		user  User
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, HashToken(plaintext), oauthKindAccess, time.Now()).Scan(
+		&token.ClientID,
+		&token.UserID,
+		pq.Array(&token.Scopes),
+		&token.Expiry,
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.DateOfBirth,
+		&user.GuardianEmail,
+		&user.ConsentedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+	return &token, &user, nil
+}
+
+// GetAuthorizations lists the clients holding unexpired tokens for the user.
+func (m OAuthTokenModel) GetAuthorizations(userID int64) ([]*OAuthAuthorization, error) {
+	query := `
+		SELECT oauth_clients.id, oauth_clients.name, array_agg(DISTINCT scope ORDER BY scope), min(oauth_tokens.created_at)
+		FROM oauth_tokens
+		INNER JOIN oauth_clients ON oauth_clients.id = oauth_tokens.client_id
+		CROSS JOIN LATERAL unnest(oauth_tokens.scopes) AS scope
+		WHERE oauth_tokens.user_id = $1 AND oauth_tokens.expiry > NOW()
+		GROUP BY oauth_clients.id, oauth_clients.name
+		ORDER BY oauth_clients.name`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	authorizations := []*OAuthAuthorization{}
+	for rows.Next() {
+		var authorization OAuthAuthorization
+		err := rows.Scan(
+			&authorization.ClientID,
+			&authorization.ClientName,
+			pq.Array(&authorization.Scopes),
+			&authorization.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		authorizations = append(authorizations, &authorization)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return authorizations, nil
+}
+
+// DeleteForClient revokes every token the user has given the client.
+func (m OAuthTokenModel) DeleteForClient(userID int64, clientID string) error {
+	query := `
+		DELETE FROM oauth_tokens
+		WHERE user_id = $1 AND client_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, clientID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteAllForUser revokes every token the user has given any client, which
+// is done when the password is reset or changed.
+func (m OAuthTokenModel) DeleteAllForUser(userID int64) error {
+	query := `
+		DELETE FROM oauth_tokens
+		WHERE user_id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// DeleteExpired removes expired authorization codes and tokens. Used refresh
+// tokens are kept until they expire, to detect their reuse.
+func (m OAuthTokenModel) DeleteExpired() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM oauth_codes WHERE expiry < NOW()`)
+	if err != nil {
+		return err
+	}
+	_, err = m.DB.ExecContext(ctx, `DELETE FROM oauth_tokens WHERE expiry < NOW()`)
+	return err
+}
@@ -1,12 +1,26 @@
 package data
 
 import (
+	"EBG.IssataySheg.net/internal/validator"
 	"context"
 	"database/sql"
+	"errors"
 	"github.com/lib/pq"
+	"regexp"
 	"time"
 )
 
+var ErrDuplicatePermission = errors.New("duplicate permission")
+
+// PermissionCodeRX matches codes of the form resource:action, such as
+// games:read or reviews:moderate.
+var PermissionCodeRX = regexp.MustCompile(`^[a-z][a-z-]*:[a-z][a-z-]*$`)
+
+type Permission struct {
+	ID   int64  `json:"id"`
+	Code string `json:"code"`
+}
+
 type Permissions []string
 
 func (p Permissions) Include(code string) bool {
@@ -18,25 +32,60 @@ func (p Permissions) Include(code string) bool {
 	return false
 }
 
+// Restrict narrows the permissions down to those among scopes, as granted to a
+// delegated credential.
+func (p Permissions) Restrict(scopes []string) Permissions {
+	permissions := Permissions{}
+	for _, code := range scopes {
+		if p.Include(code) {
+			permissions = append(permissions, code)
+		}
+	}
+	return permissions
+}
+
 type PermissionModel struct {
 	DB *sql.DB
 }
 
+// GetAllForUser returns the user's effective permissions: the codes granted to
+// them directly along with those bundled in their roles.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	query := `
 SELECT permissions.code
 FROM permissions
 INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-INNER JOIN users ON users_permissions.user_id = users.id
-WHERE users.id = $1`
+WHERE users_permissions.user_id = $1
+UNION
+SELECT permissions.code
+FROM permissions
+INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+WHERE users_roles.user_id = $1
+ORDER BY code`
+	return m.getCodes(query, userID)
+}
+
+// GetDirectForUser returns only the codes granted to the user directly.
+func (m PermissionModel) GetDirectForUser(userID int64) (Permissions, error) {
+	query := `
+SELECT permissions.code
+FROM permissions
+INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+WHERE users_permissions.user_id = $1
+ORDER BY permissions.code`
+	return m.getCodes(query, userID)
+}
+
+func (m PermissionModel) getCodes(query string, id int64) (Permissions, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	rows, err := m.DB.QueryContext(ctx, query, userID)
+	rows, err := m.DB.QueryContext(ctx, query, id)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var permissions Permissions
+	permissions := Permissions{}
 	for rows.Next() {
 		var permission string
 		err := rows.Scan(&permission)
@@ -50,12 +99,89 @@ WHERE users.id = $1`
 	}
 	return permissions, nil
 }
+func ValidatePermissionCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) <= 100, "code", "must not be more than 100 bytes long")
+	v.Check(validator.Matches(code, PermissionCodeRX), "code", "must be of the form resource:action")
+}
+
+func (m PermissionModel) GetAll() ([]*Permission, error) {
+	query := `
+SELECT id, code
+FROM permissions
+ORDER BY code ASC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	permissions := []*Permission{}
+	for rows.Next() {
+		var permission Permission
+		err := rows.Scan(&permission.ID, &permission.Code)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, &permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+func (m PermissionModel) Insert(permission *Permission) error {
+	query := `
+INSERT INTO permissions (code)
+VALUES ($1)
+RETURNING id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, permission.Code).Scan(&permission.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "permissions_code_key"`:
+			return ErrDuplicatePermission
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// AddForUser grants the permission codes to the user. Codes the user already
+// holds are left alone, and unknown codes are ignored.
 func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	query := `
 INSERT INTO users_permissions
-SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+ON CONFLICT DO NOTHING`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
+
+// RemoveForUser takes the permission codes away from the user. It returns
+// ErrRecordNotFound if the user held none of them directly.
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+DELETE FROM users_permissions
+WHERE user_id = $1
+AND permission_id IN (SELECT id FROM permissions WHERE code = ANY($2))`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
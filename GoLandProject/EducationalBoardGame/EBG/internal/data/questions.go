@@ -0,0 +1,330 @@
+package data
+
+import (
+	"EBG.IssataySheg.net/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	QuestionMultipleChoice = "multiple_choice"
+	QuestionTrueFalse      = "true_false"
+	QuestionNumeric        = "numeric"
+	QuestionFreeText       = "free_text"
+)
+
+var (
+	QuestionKinds = []string{QuestionMultipleChoice, QuestionTrueFalse, QuestionNumeric, QuestionFreeText}
+
+	ErrUnknownGame = errors.New("unknown game")
+)
+
+type Question struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Kind       string    `json:"kind"`
+	Prompt     string    `json:"prompt"`
+	Choices    []string  `json:"choices,omitempty"`
+	Answers    []string  `json:"answers,omitempty"`
+	Tolerance  float64   `json:"tolerance,omitempty"`
+	Subject    string    `json:"subject,omitempty"`
+	Difficulty string    `json:"difficulty,omitempty"`
+	GameIDs    []int64   `json:"game_ids"`
+	Version    int32     `json:"version"`
+}
+
+// HideAnswers removes everything that would let a player work out the answer
+// without knowing it, so the question can be shown to people playing with it.
+func (q *Question) HideAnswers() {
+	q.Answers = nil
+	q.Tolerance = 0
+}
+
+// Check reports whether answer is correct. Free text answers are compared
+// ignoring case and surrounding or repeated whitespace.
+func (q *Question) Check(answer string) bool {
+	switch q.Kind {
+	case QuestionNumeric:
+		given, err := strconv.ParseFloat(strings.TrimSpace(answer), 64)
+		if err != nil || len(q.Answers) == 0 {
+			return false
+		}
+		expected, err := strconv.ParseFloat(q.Answers[0], 64)
+		if err != nil {
+			return false
+		}
+		return math.Abs(given-expected) <= q.Tolerance
+	case QuestionFreeText, QuestionTrueFalse:
+		for _, accepted := range q.Answers {
+			if normalizeAnswer(accepted) == normalizeAnswer(answer) {
+				return true
+			}
+		}
+		return false
+	default:
+		return validator.In(answer, q.Answers...)
+	}
+}
+
+func normalizeAnswer(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+func ValidateQuestion(v *validator.Validator, q *Question) {
+	v.Check(q.Prompt != "", "prompt", "must be provided")
+	v.Check(len(q.Prompt) <= 2000, "prompt", "must not be more than 2000 bytes long")
+	v.Check(len(q.Subject) <= 100, "subject", "must not be more than 100 bytes long")
+	v.Check(q.Difficulty == "" || validator.In(q.Difficulty, DifficultyLevels...), "difficulty", "must be one of easy, medium or hard")
+	v.Check(validator.Unique(q.GameIDs), "game_ids", "must not contain duplicate values")
+	v.Check(q.Answers != nil, "answers", "must be provided")
+	v.Check(q.Kind == QuestionNumeric || q.Tolerance == 0, "tolerance", "must only be given for numeric questions")
+	switch q.Kind {
+	case QuestionMultipleChoice:
+		v.Check(len(q.Choices) >= 2, "choices", "must contain at least 2 choices")
+		v.Check(len(q.Choices) <= 10, "choices", "must not contain more than 10 choices")
+		v.Check(validator.Unique(q.Choices), "choices", "must not contain duplicate values")
+		v.Check(len(q.Answers) >= 1, "answers", "must contain at least 1 answer")
+		for _, answer := range q.Answers {
+			v.Check(validator.In(answer, q.Choices...), "answers", "must only contain values from choices")
+		}
+	case QuestionTrueFalse:
+		v.Check(len(q.Choices) == 0, "choices", "must not be given for true/false questions")
+		v.Check(len(q.Answers) == 1, "answers", "must contain exactly 1 answer")
+		for _, answer := range q.Answers {
+			v.Check(validator.In(answer, "true", "false"), "answers", "must be true or false")
+		}
+	case QuestionNumeric:
+		v.Check(len(q.Choices) == 0, "choices", "must not be given for numeric questions")
+		v.Check(len(q.Answers) == 1, "answers", "must contain exactly 1 answer")
+		for _, answer := range q.Answers {
+			_, err := strconv.ParseFloat(answer, 64)
+			v.Check(err == nil, "answers", "must be a number")
+		}
+		v.Check(q.Tolerance >= 0, "tolerance", "must not be negative")
+	case QuestionFreeText:
+		v.Check(len(q.Choices) == 0, "choices", "must not be given for free text questions")
+		v.Check(len(q.Answers) >= 1, "answers", "must contain at least 1 accepted answer")
+		v.Check(len(q.Answers) <= 20, "answers", "must not contain more than 20 accepted answers")
+		for _, answer := range q.Answers {
+			v.Check(strings.TrimSpace(answer) != "", "answers", "must not contain blank answers")
+		}
+	default:
+		v.AddError("kind", "must be one of multiple_choice, true_false, numeric or free_text")
+	}
+}
+
+type QuestionQuery struct {
+	GameID     int64
+	Subject    string
+	Difficulty string
+	Kind       string
+}
+
+func ValidateQuestionQuery(v *validator.Validator, q QuestionQuery) {
+	v.Check(q.GameID >= 0, "game_id", "must not be negative")
+	v.Check(q.Difficulty == "" || validator.In(q.Difficulty, DifficultyLevels...), "difficulty", "must be one of easy, medium or hard")
+	v.Check(q.Kind == "" || validator.In(q.Kind, QuestionKinds...), "kind", "must be one of multiple_choice, true_false, numeric or free_text")
+}
+
+type QuestionModel struct {
+	DB *sql.DB
+}
+
+const questionGameIDs = "array(SELECT game_id FROM questions_games WHERE question_id = questions.id ORDER BY game_id)"
+
+func (q *Question) scanDest() []interface{} {
+	return []interface{}{
+		&q.ID,
+		&q.CreatedAt,
+		&q.Kind,
+		&q.Prompt,
+		pq.Array(&q.Choices),
+		pq.Array(&q.Answers),
+		&q.Tolerance,
+		&q.Subject,
+		&q.Difficulty,
+		pq.Array(&q.GameIDs),
+		&q.Version,
+	}
+}
+
+func (m QuestionModel) Insert(question *Question) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `
+		INSERT INTO questions (kind, prompt, choices, answers, tolerance, subject, difficulty)
+		VALUES ($1, $2, coalesce($3::text[], '{}'), $4, $5, $6, $7)
+		RETURNING id, created_at, version`
+	args := []interface{}{
+		question.Kind,
+		question.Prompt,
+		pq.Array(question.Choices),
+		pq.Array(question.Answers),
+		question.Tolerance,
+		question.Subject,
+		question.Difficulty,
+	}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&question.ID, &question.CreatedAt, &question.Version)
+	if err != nil {
+		return err
+	}
+	err = m.linkGames(ctx, tx, question)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// linkGames replaces the games a question is linked to with question.GameIDs.
+func (m QuestionModel) linkGames(ctx context.Context, tx *sql.Tx, question *Question) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM questions_games WHERE question_id = $1`, question.ID)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO questions_games (question_id, game_id)
+		SELECT $1, unnest($2::bigint[])`
+	_, err = tx.ExecContext(ctx, query, question.ID, pq.Array(question.GameIDs))
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "questions_games" violates foreign key constraint "questions_games_game_id_fkey"`:
+			return ErrUnknownGame
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m QuestionModel) Get(id int64) (*Question, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, kind, prompt, choices, answers, tolerance, subject, difficulty, ` + questionGameIDs + `, version
+		FROM questions
+		WHERE id = $1`
+	var question Question
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(question.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &question, nil
+}
+
+func (m QuestionModel) Update(question *Question) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `
+		UPDATE questions
+		SET kind = $1, prompt = $2, choices = coalesce($3::text[], '{}'), answers = $4, tolerance = $5, subject = $6, difficulty = $7, version = version + 1
+		WHERE id = $8 AND version = $9
+		RETURNING version`
+	args := []interface{}{
+		question.Kind,
+		question.Prompt,
+		pq.Array(question.Choices),
+		pq.Array(question.Answers),
+		question.Tolerance,
+		question.Subject,
+		question.Difficulty,
+		question.ID,
+		question.Version,
+	}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&question.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	err = m.linkGames(ctx, tx, question)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m QuestionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM questions
+		WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m QuestionModel) GetAll(q QuestionQuery, filters Filters) ([]*Question, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, kind, prompt, choices, answers, tolerance, subject, difficulty, %s, version
+		FROM questions
+		WHERE (id IN (SELECT question_id FROM questions_games WHERE game_id = $1) OR $1 = 0)
+		AND (lower(subject) = lower($2) OR $2 = '')
+		AND (difficulty = $3 OR $3 = '')
+		AND (kind = $4 OR $4 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $5 OFFSET $6`, questionGameIDs, filters.sortColumn(), filters.sortDirection())
+	args := []interface{}{q.GameID, q.Subject, q.Difficulty, q.Kind, filters.limit(), filters.offset()}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	questions := []*Question{}
+	for rows.Next() {
+		var question Question
+		err := rows.Scan(append([]interface{}{&totalRecords}, question.scanDest()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		questions = append(questions, &question)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return questions, metadata, nil
+}
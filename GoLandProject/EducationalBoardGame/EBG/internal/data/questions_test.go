@@ -0,0 +1,37 @@
+package data
+
+import "testing"
+
+func TestQuestionWithoutChoices(t *testing.T) {
+	m := QuestionModel{DB: newTestDB(t)}
+	question := &Question{
+		Kind:    QuestionFreeText,
+		Prompt:  "Name the largest planet.",
+		Answers: []string{"Jupiter"},
+	}
+	if err := m.Insert(question); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Delete(question.ID) })
+
+	got, err := m.Get(question.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Choices == nil || len(got.Choices) != 0 {
+		t.Errorf("got choices %#v; want an empty list", got.Choices)
+	}
+
+	got.Prompt = "Name the largest planet in the solar system."
+	got.Choices = nil
+	if err := m.Update(got); err != nil {
+		t.Fatal(err)
+	}
+	got, err = m.Get(question.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Choices == nil || len(got.Choices) != 0 {
+		t.Errorf("after update: got choices %#v; want an empty list", got.Choices)
+	}
+}
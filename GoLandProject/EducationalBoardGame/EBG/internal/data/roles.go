@@ -0,0 +1,168 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"time"
+)
+
+// Role bundles permission codes so that they can be granted to many users at
+// once. A user's effective permissions are the union of their direct grants
+// and the permissions of all their roles.
+type Role struct {
+	ID          int64       `json:"id"`
+	Code        string      `json:"code"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
+type RoleModel struct {
+	DB *sql.DB
+}
+
+const roleQuery = `
+SELECT roles.id, roles.code, roles.name,
+       COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+FROM roles
+LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id`
+
+func (role *Role) scanDest() []interface{} {
+	return []interface{}{&role.ID, &role.Code, &role.Name, pq.Array((*[]string)(&role.Permissions))}
+}
+
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := roleQuery + `
+GROUP BY roles.id
+ORDER BY roles.id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := []*Role{}
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(role.scanDest()...)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (m RoleModel) Get(code string) (*Role, error) {
+	query := roleQuery + `
+WHERE roles.code = $1
+GROUP BY roles.id`
+	var role Role
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, code).Scan(role.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &role, nil
+}
+
+// SetPermissions replaces the permission codes bundled in the role with
+// role.Permissions. Unknown codes are ignored.
+func (m RoleModel) SetPermissions(role *Role) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM roles_permissions WHERE role_id = $1`, role.ID)
+	if err != nil {
+		return err
+	}
+	query := `
+INSERT INTO roles_permissions
+SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+	_, err = tx.ExecContext(ctx, query, role.ID, pq.Array(role.Permissions))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	query := `
+SELECT roles.code
+FROM roles
+INNER JOIN users_roles ON users_roles.role_id = roles.id
+WHERE users_roles.user_id = $1
+ORDER BY roles.id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		err := rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// AddForUser assigns the roles to the user, leaving alone any they already
+// have. Unknown role codes are ignored.
+func (m RoleModel) AddForUser(userID int64, codes ...string) error {
+	query := `
+INSERT INTO users_roles
+SELECT $1, roles.id FROM roles WHERE roles.code = ANY($2)
+ON CONFLICT DO NOTHING`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+// RemoveForUser takes the roles away from the user. It returns
+// ErrRecordNotFound if the user had none of them.
+func (m RoleModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+DELETE FROM users_roles
+WHERE user_id = $1
+AND role_id IN (SELECT id FROM roles WHERE code = ANY($2))`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,371 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	SessionWaiting  = "waiting"
+	SessionActive   = "active"
+	SessionFinished = "finished"
+)
+
+var (
+	ErrDuplicatePlayer = errors.New("duplicate player")
+)
+
+type Session struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	GameID    int64           `json:"game_id"`
+	HostID    int64           `json:"host_id"`
+	Status    string          `json:"status"`
+	Turn      int32           `json:"turn"`
+	State     json.RawMessage `json:"state"`
+	Players   []SessionPlayer `json:"players"`
+	Version   int32           `json:"version"`
+}
+
+type SessionPlayer struct {
+	UserID   int64      `json:"user_id"`
+	Name     string     `json:"name"`
+	Seat     int32      `json:"seat"`
+	JoinedAt *time.Time `json:"joined_at,omitempty"`
+}
+
+func (s *Session) Player(userID int64) *SessionPlayer {
+	for i := range s.Players {
+		if s.Players[i].UserID == userID {
+			return &s.Players[i]
+		}
+	}
+	return nil
+}
+
+// CurrentPlayer returns the ID of the user whose seat matches the session's
+// turn, or zero if the seat is empty.
+func (s *Session) CurrentPlayer() int64 {
+	for _, player := range s.Players {
+		if player.Seat == s.Turn {
+			return player.UserID
+		}
+	}
+	return 0
+}
+
+// AdvanceTurn passes the turn to the player in the next occupied seat, wrapping
+// around to the lowest seat. Players are kept ordered by seat.
+func (s *Session) AdvanceTurn() {
+	if len(s.Players) == 0 {
+		return
+	}
+	for _, player := range s.Players {
+		if player.Seat > s.Turn {
+			s.Turn = player.Seat
+			return
+		}
+	}
+	s.Turn = s.Players[0].Seat
+}
+
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// Insert creates the session with its host in seat zero and the invited users
+// in the following seats, in the order given. The host counts as having joined,
+// since they are the one who will start the session.
+func (m SessionModel) Insert(session *Session, invited []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if session.State == nil {
+		session.State = json.RawMessage("{}")
+	}
+	query := `
+		INSERT INTO sessions (game_id, host_id, status, turn, state)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, version`
+	args := []interface{}{session.GameID, session.HostID, session.Status, session.Turn, []byte(session.State)}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&session.ID, &session.CreatedAt, &session.Version)
+	if err != nil {
+		return err
+	}
+	query = `
+		INSERT INTO sessions_players (session_id, user_id, seat, joined_at)
+		VALUES ($1, $2, $3, CASE WHEN $4 THEN NOW() END)`
+	for seat, userID := range append([]int64{session.HostID}, invited...) {
+		_, err = tx.ExecContext(ctx, query, session.ID, userID, seat, userID == session.HostID)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "sessions_players_pkey"`:
+				return ErrDuplicatePlayer
+			case err.Error() == `pq: insert or update on table "sessions_players" violates foreign key constraint "sessions_players_user_id_fkey"`:
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	return m.getPlayers(session)
+}
+
+func (m SessionModel) Get(id int64) (*Session, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, game_id, host_id, status, turn, state, version
+		FROM sessions
+		WHERE id = $1`
+	var session Session
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&session.ID,
+		&session.CreatedAt,
+		&session.GameID,
+		&session.HostID,
+		&session.Status,
+		&session.Turn,
+		(*[]byte)(&session.State),
+		&session.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	err = m.getPlayers(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (m SessionModel) getPlayers(session *Session) error {
+	query := `
+		SELECT users.id, users.name, sessions_players.seat, sessions_players.joined_at
+		FROM sessions_players
+		INNER JOIN users ON users.id = sessions_players.user_id
+		WHERE sessions_players.session_id = $1
+		ORDER BY sessions_players.seat ASC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, session.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	session.Players = []SessionPlayer{}
+	for rows.Next() {
+		var player SessionPlayer
+		err := rows.Scan(&player.UserID, &player.Name, &player.Seat, &player.JoinedAt)
+		if err != nil {
+			return err
+		}
+		session.Players = append(session.Players, player)
+	}
+	return rows.Err()
+}
+
+// GetAllForUser returns a page of the sessions the user plays in, each with
+// its players. The page is chosen and its players read in a single query, so
+// a session deleted meanwhile is simply left out.
+func (m SessionModel) GetAllForUser(userID int64, filters Filters) ([]*Session, Metadata, error) {
+	query := fmt.Sprintf(`
+		WITH page AS (
+			SELECT count(*) OVER() AS total, id, created_at, game_id, host_id, status, turn, state, version
+			FROM sessions
+			WHERE EXISTS (
+				SELECT 1 FROM sessions_players
+				WHERE sessions_players.session_id = sessions.id AND sessions_players.user_id = $1)
+			ORDER BY %[1]s %[2]s, id ASC
+			LIMIT $2 OFFSET $3)
+		SELECT page.total, page.id, page.created_at, page.game_id, page.host_id, page.status, page.turn, page.state, page.version,
+			users.id, users.name, sessions_players.seat, sessions_players.joined_at
+		FROM page
+		INNER JOIN sessions_players ON sessions_players.session_id = page.id
+		INNER JOIN users ON users.id = sessions_players.user_id
+		ORDER BY page.%[1]s %[2]s, page.id ASC, sessions_players.seat ASC`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		var player SessionPlayer
+		err := rows.Scan(
+			&totalRecords,
+			&session.ID,
+			&session.CreatedAt,
+			&session.GameID,
+			&session.HostID,
+			&session.Status,
+			&session.Turn,
+			(*[]byte)(&session.State),
+			&session.Version,
+			&player.UserID,
+			&player.Name,
+			&player.Seat,
+			&player.JoinedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		if len(sessions) == 0 || sessions[len(sessions)-1].ID != session.ID {
+			session.Players = []SessionPlayer{}
+			sessions = append(sessions, &session)
+		}
+		last := sessions[len(sessions)-1]
+		last.Players = append(last.Players, player)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return sessions, metadata, nil
+}
+
+// AddPlayer invites a user to the session, seating them after every existing
+// player.
+func (m SessionModel) AddPlayer(session *Session, userID int64) error {
+	query := `
+		INSERT INTO sessions_players (session_id, user_id, seat)
+		SELECT $1, $2, coalesce(max(seat), -1) + 1
+		FROM sessions_players
+		WHERE session_id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, session.ID, userID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "sessions_players_pkey"`:
+			return ErrDuplicatePlayer
+		case err.Error() == `pq: insert or update on table "sessions_players" violates foreign key constraint "sessions_players_user_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return m.getPlayers(session)
+}
+
+// MarkJoined records that the user has opened the session. The session's
+// version is bumped along with it, so that a concurrent Start, which seats
+// only the players who had joined when it read the session, fails with an edit
+// conflict rather than leaving the newcomer out of the game state.
+func (m SessionModel) MarkJoined(session *Session, userID int64) error {
+	query := `
+		WITH joined AS (
+			UPDATE sessions_players
+			SET joined_at = NOW()
+			WHERE session_id = $1 AND user_id = $2 AND joined_at IS NULL
+			RETURNING session_id)
+		UPDATE sessions
+		SET version = version + 1
+		WHERE id IN (SELECT session_id FROM joined)
+		RETURNING version`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, session.ID, userID).Scan(&session.Version)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return m.getPlayers(session)
+}
+
+// Start saves the session's new status, turn and state and drops the invited
+// users who never joined, other than the host, so that turns only pass between
+// players who are present. Both happen in one transaction, so nobody is removed
+// unless the session is saved.
+func (m SessionModel) Start(session *Session) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `
+		UPDATE sessions
+		SET status = $1, turn = $2, state = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+	args := []interface{}{
+		session.Status,
+		session.Turn,
+		[]byte(session.State),
+		session.ID,
+		session.Version,
+	}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&session.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	query = `
+		DELETE FROM sessions_players
+		WHERE session_id = $1 AND joined_at IS NULL AND user_id <> $2`
+	_, err = tx.ExecContext(ctx, query, session.ID, session.HostID)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	return m.getPlayers(session)
+}
+
+func (m SessionModel) Update(session *Session) error {
+	query := `
+		UPDATE sessions
+		SET status = $1, turn = $2, state = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+	args := []interface{}{
+		session.Status,
+		session.Turn,
+		[]byte(session.State),
+		session.ID,
+		session.Version,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&session.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
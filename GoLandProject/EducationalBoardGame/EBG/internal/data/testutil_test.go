@@ -0,0 +1,14 @@
+package data
+
+import (
+	"EBG.IssataySheg.net/internal/data/datatest"
+	"database/sql"
+	"testing"
+)
+
+// newTestDB opens the database given by the EBG_TEST_DB_DSN environment
+// variable, which must have every migration applied; without one the test is
+// skipped.
+func newTestDB(t *testing.T) *sql.DB {
+	return datatest.NewDB(t)
+}
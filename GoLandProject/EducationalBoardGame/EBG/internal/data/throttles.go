@@ -0,0 +1,117 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LockoutPolicy decides how long an account is locked after repeated failed
+// sign-ins. Once Threshold consecutive attempts have failed the account is
+// locked for Base, and every further failure doubles that, up to Max. Failures
+// are forgotten after a day without any.
+type LockoutPolicy struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+}
+
+// Duration returns how long to lock the account for after the given number of
+// consecutive failures, which is zero below the threshold.
+func (p LockoutPolicy) Duration(failures int) time.Duration {
+	if p.Threshold < 1 || failures < p.Threshold {
+		return 0
+	}
+	d := p.Base
+	for i := p.Threshold; i < failures && d < p.Max; i++ {
+		d *= 2
+	}
+	if d > p.Max {
+		d = p.Max
+	}
+	return d
+}
+
+// LoginThrottle tracks failed sign-ins for an email address. Throttles are
+// kept by address rather than by user so that unknown addresses are treated
+// exactly like registered ones.
+type LoginThrottle struct {
+	Email       string
+	Failures    int
+	LockedUntil *time.Time
+}
+
+// Locked reports whether the address is currently locked out.
+func (t *LoginThrottle) Locked() bool {
+	return t.LockedUntil != nil && t.LockedUntil.After(time.Now())
+}
+
+type LoginThrottleModel struct {
+	DB *sql.DB
+}
+
+func (m LoginThrottleModel) Get(email string) (*LoginThrottle, error) {
+	query := `
+		SELECT email, failures, locked_until
+		FROM login_throttles
+		WHERE email = $1`
+	var throttle LoginThrottle
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&throttle.Email, &throttle.Failures, &throttle.LockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return &LoginThrottle{Email: email}, nil
+		default:
+			return nil, err
+		}
+	}
+	return &throttle, nil
+}
+
+// RecordFailure counts a failed sign-in for the address and locks it if the
+// policy says so.
+func (m LoginThrottleModel) RecordFailure(email string, policy LockoutPolicy) (*LoginThrottle, error) {
+	query := `
+		INSERT INTO login_throttles (email, failures)
+		VALUES ($1, 1)
+		ON CONFLICT (email) DO UPDATE
+		SET failures = CASE
+		        WHEN login_throttles.last_failure_at < NOW() - INTERVAL '24 hours' THEN 1
+		        ELSE login_throttles.failures + 1
+		    END,
+		    last_failure_at = NOW()
+		RETURNING email, failures`
+	var throttle LoginThrottle
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&throttle.Email, &throttle.Failures)
+	if err != nil {
+		return nil, err
+	}
+	d := policy.Duration(throttle.Failures)
+	if d == 0 {
+		return &throttle, nil
+	}
+	lockedUntil := time.Now().Add(d)
+	throttle.LockedUntil = &lockedUntil
+	_, err = m.DB.ExecContext(ctx, `UPDATE login_throttles SET locked_until = $2 WHERE email = $1`, email, lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+// Reset clears the failures and any lockout for the address, after a
+// successful sign-in or when an admin unlocks the account.
+func (m LoginThrottleModel) Reset(email string) error {
+	query := `
+		DELETE FROM login_throttles
+		WHERE email = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, email)
+	return err
+}
@@ -7,20 +7,55 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 )
 
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopeTwoFactor      = "two-factor"
+	ScopeEmailChange    = "email-change"
+	ScopeConsent        = "consent"
 )
 
+// ErrTokenReused is returned when a refresh token is presented a second time.
+// A legitimate client never does that, so the whole token family is revoked.
+var ErrTokenReused = errors.New("refresh token reused")
+
+// maxUserAgentLength bounds the User-Agent header stored alongside a token.
+const maxUserAgentLength = 256
+
 type Token struct {
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	Family    int64     `json:"-"`
+	UserAgent string    `json:"-"`
+}
+
+// TokenPair is a short-lived access token together with the refresh token
+// which can be exchanged for the next pair. Both belong to the same family,
+// which stands for a single sign-in on a single device.
+type TokenPair struct {
+	Access  *Token
+	Refresh *Token
+}
+
+// ActiveToken describes one sign-in, that is one token family, without
+// revealing any token plaintext, so that users can review and revoke the
+// devices they are signed in on.
+type ActiveToken struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Expiry     time.Time `json:"expiry"`
+	UserAgent  string    `json:"user_agent"`
+	Current    bool      `json:"current"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -35,11 +70,15 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 		return nil, err
 	}
 	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+	token.Hash = HashToken(token.Plaintext)
 	return token, nil
 }
 
+func HashToken(tokenPlaintext string) []byte {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	return hash[:]
+}
+
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
@@ -58,15 +97,126 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 func (m TokenModel) Insert(token *Token) error {
-	query := `
-INSERT INTO tokens (hash, user_id, expiry, scope)
-VALUES ($1, $2, $3, $4)`
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	_, err := m.DB.ExecContext(ctx, insertTokenQuery, token.args()...)
 	return err
 }
+
+const insertTokenQuery = `
+INSERT INTO tokens (hash, user_id, expiry, scope, family, user_agent)
+VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6)`
+
+func (token *Token) args() []interface{} {
+	return []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.Family, token.UserAgent}
+}
+
+// NewPair signs the user in by starting a new token family.
+func (m TokenModel) NewPair(userID int64, accessTTL, refreshTTL time.Duration, userAgent string) (*TokenPair, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var family int64
+	err = tx.QueryRowContext(ctx, `SELECT nextval('tokens_family_seq')`).Scan(&family)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := m.insertPair(ctx, tx, userID, family, accessTTL, refreshTTL, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	return pair, tx.Commit()
+}
+
+// Rotate exchanges a refresh token for a new pair in the same family. The old
+// refresh token is marked as used rather than deleted, so that a later attempt
+// to use it again is detected; that revokes the family and returns
+// ErrTokenReused. Access tokens issued earlier in the family are revoked.
+func (m TokenModel) Rotate(refreshPlaintext string, accessTTL, refreshTTL time.Duration, userAgent string) (*TokenPair, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	query := `
+SELECT user_id, family, used_at
+FROM tokens
+WHERE hash = $1 AND scope = $2 AND expiry > $3
+FOR UPDATE`
+	var (
+		userID int64
+		family int64
+		usedAt sql.NullTime
+	)
+	hash := HashToken(refreshPlaintext)
+	err = tx.QueryRowContext(ctx, query, hash, ScopeRefresh, time.Now()).Scan(&userID, &family, &usedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if usedAt.Valid {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1`, family)
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrTokenReused
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET used_at = NOW() WHERE hash = $1`, hash)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1 AND scope = $2`, family, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := m.insertPair(ctx, tx, userID, family, accessTTL, refreshTTL, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	return pair, tx.Commit()
+}
+
+func (m TokenModel) insertPair(ctx context.Context, tx *sql.Tx, userID, family int64, accessTTL, refreshTTL time.Duration, userAgent string) (*TokenPair, error) {
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	var pair TokenPair
+	for _, t := range []struct {
+		token **Token
+		ttl   time.Duration
+		scope string
+	}{
+		{&pair.Access, accessTTL, ScopeAuthentication},
+		{&pair.Refresh, refreshTTL, ScopeRefresh},
+	} {
+		token, err := generateToken(userID, t.ttl, t.scope)
+		if err != nil {
+			return nil, err
+		}
+		token.Family = family
+		token.UserAgent = userAgent
+		_, err = tx.ExecContext(ctx, insertTokenQuery, token.args()...)
+		if err != nil {
+			return nil, err
+		}
+		*t.token = token
+	}
+	return &pair, nil
+}
 func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	query := `
 DELETE FROM tokens
@@ -76,3 +226,121 @@ WHERE scope = $1 AND user_id = $2`
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteAll revokes every token the user holds, whatever its scope.
+func (m TokenModel) DeleteAll(userID int64) error {
+	query := `
+DELETE FROM tokens
+WHERE user_id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// DeleteFamilyForToken signs out of the device the token was issued to by
+// revoking every token in its family.
+func (m TokenModel) DeleteFamilyForToken(tokenPlaintext string) error {
+	query := `
+DELETE FROM tokens
+WHERE hash = $1 OR family = (SELECT family FROM tokens WHERE hash = $1)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, HashToken(tokenPlaintext))
+	return err
+}
+
+// DeleteFamily revokes a single sign-in, provided it belongs to the user.
+func (m TokenModel) DeleteFamily(userID, family int64) error {
+	query := `
+DELETE FROM tokens
+WHERE user_id = $1 AND family = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, family)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Touch records that the token has just been used. To avoid a write on every
+// request, last_used_at is only updated once it is more than a minute old.
+func (m TokenModel) Touch(tokenPlaintext string) error {
+	query := `
+UPDATE tokens
+SET last_used_at = NOW()
+WHERE hash = $1 AND last_used_at < NOW() - INTERVAL '1 minute'`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, HashToken(tokenPlaintext))
+	return err
+}
+
+// GetAllForUser returns the user's active sign-ins, one per token family, most
+// recently used first. A family is active while it holds an unexpired access
+// token or an unused, unexpired refresh token. The family of the current token
+// is flagged as such.
+func (m TokenModel) GetAllForUser(userID int64, current string) ([]*ActiveToken, error) {
+	query := `
+SELECT family, min(created_at), max(last_used_at), max(expiry),
+       (array_agg(user_agent ORDER BY id))[1], bool_or(hash = $4)
+FROM tokens
+WHERE user_id = $1 AND scope IN ($2, $3) AND family IS NOT NULL AND expiry > $5
+GROUP BY family
+HAVING bool_or(used_at IS NULL)
+ORDER BY max(last_used_at) DESC, family DESC`
+	args := []interface{}{userID, ScopeAuthentication, ScopeRefresh, HashToken(current), time.Now()}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []*ActiveToken{}
+	for rows.Next() {
+		var token ActiveToken
+		err := rows.Scan(&token.ID, &token.CreatedAt, &token.LastUsedAt, &token.Expiry, &token.UserAgent, &token.Current)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// DeleteSignIns signs the user out everywhere. Unlike DeleteAll it leaves
+// tokens of other scopes, such as a pending email change, in place.
+func (m TokenModel) DeleteSignIns(userID int64) error {
+	query := `
+DELETE FROM tokens
+WHERE user_id = $1 AND scope IN ($2, $3)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, ScopeAuthentication, ScopeRefresh)
+	return err
+}
+
+// DeleteOthers signs the user out everywhere except on the device the current
+// token was issued to.
+func (m TokenModel) DeleteOthers(userID int64, current string) error {
+	query := `
+DELETE FROM tokens
+WHERE user_id = $1 AND scope IN ($2, $3)
+AND family IS DISTINCT FROM (SELECT family FROM tokens WHERE hash = $4)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, ScopeAuthentication, ScopeRefresh, HashToken(current))
+	return err
+}
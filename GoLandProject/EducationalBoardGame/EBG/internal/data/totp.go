@@ -0,0 +1,216 @@
+package data
+
+import (
+	"EBG.IssataySheg.net/internal/validator"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+var ErrTOTPEnabled = errors.New("two-factor authentication already enabled")
+
+// TOTP holds a user's two-factor authentication secret. It only protects the
+// account once Confirmed, which happens when the user proves their
+// authenticator app works by submitting a first code.
+type TOTP struct {
+	UserID    int64
+	CreatedAt time.Time
+	Secret    string
+	Confirmed bool
+	LastStep  int64
+}
+
+// GenerateRecoveryCodes returns single-use codes which can be given instead of
+// a TOTP code when the user has lost their authenticator. They are formatted
+// as two groups of five characters to make them easier to copy by hand.
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		randomBytes := make([]byte, 7)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(randomBytes))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6, "code", "must be 6 digits long")
+}
+
+type TOTPModel struct {
+	DB *sql.DB
+}
+
+func (m TOTPModel) Get(userID int64) (*TOTP, error) {
+	query := `
+		SELECT user_id, created_at, secret, confirmed, last_step
+		FROM users_totp
+		WHERE user_id = $1`
+	var totp TOTP
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&totp.UserID,
+		&totp.CreatedAt,
+		&totp.Secret,
+		&totp.Confirmed,
+		&totp.LastStep,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &totp, nil
+}
+
+// Enrol stores a new, unconfirmed secret for the user, replacing any earlier
+// enrolment that was never confirmed. It returns ErrTOTPEnabled if two-factor
+// authentication is already switched on.
+func (m TOTPModel) Enrol(totp *TOTP) error {
+	query := `
+		INSERT INTO users_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET created_at = NOW(), secret = EXCLUDED.secret, last_step = 0
+		WHERE users_totp.confirmed = false
+		RETURNING created_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, totp.UserID, totp.Secret).Scan(&totp.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrTOTPEnabled
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Confirm switches two-factor authentication on, recording the time step of
+// the code the user confirmed with and replacing their recovery codes.
+func (m TOTPModel) Confirm(totp *TOTP, step int64, recoveryCodes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `
+		UPDATE users_totp
+		SET confirmed = true, last_step = $2
+		WHERE user_id = $1 AND confirmed = false`
+	result, err := tx.ExecContext(ctx, query, totp.UserID, step)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTOTPEnabled
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_recovery_codes WHERE user_id = $1`, totp.UserID)
+	if err != nil {
+		return err
+	}
+	for _, code := range recoveryCodes {
+		query := `
+			INSERT INTO users_recovery_codes (user_id, hash)
+			VALUES ($1, $2)`
+		_, err = tx.ExecContext(ctx, query, totp.UserID, HashToken(code))
+		if err != nil {
+			return err
+		}
+	}
+	totp.Confirmed = true
+	totp.LastStep = step
+	return tx.Commit()
+}
+
+// UseStep records that the code for the time step has been used. It reports
+// false if that step, or a later one, was already used, which means the code
+// is being replayed.
+func (m TOTPModel) UseStep(userID, step int64) (bool, error) {
+	query := `
+		UPDATE users_totp
+		SET last_step = $2
+		WHERE user_id = $1 AND last_step < $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode marks the recovery code as used, reporting false if it does
+// not belong to the user or has been used before.
+func (m TOTPModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	query := `
+		UPDATE users_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND hash = $2 AND used_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, HashToken(strings.ToLower(strings.TrimSpace(code))))
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// Delete switches two-factor authentication off and discards the user's
+// recovery codes.
+func (m TOTPModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
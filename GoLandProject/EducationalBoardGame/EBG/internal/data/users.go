@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"golang.org/x/crypto/bcrypt"
 	"time"
 )
@@ -24,12 +25,23 @@ type User struct {
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
 	Version   int       `json:"-"`
+
+	DateOfBirth   *Date      `json:"date_of_birth,omitempty"`
+	GuardianEmail string     `json:"guardian_email,omitempty"`
+	ConsentedAt   *time.Time `json:"consented_at,omitempty"`
 }
 
 func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+// NeedsConsent reports whether the user is younger than age and no guardian
+// has consented to the account yet. Users who have not given a date of birth
+// never need consent.
+func (u *User) NeedsConsent(age int) bool {
+	return u.DateOfBirth != nil && u.ConsentedAt == nil && u.DateOfBirth.Age(time.Now()) < age
+}
+
 type password struct {
 	plaintext *string
 	hash      []byte
@@ -69,6 +81,13 @@ func ValidateUser(v *validator.Validator, user *User) {
 	v.Check(user.Name != "", "name", "must be provided")
 	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
 	ValidateEmail(v, user.Email)
+	if user.DateOfBirth != nil {
+		v.Check(user.DateOfBirth.Before(time.Now()), "date_of_birth", "must be in the past")
+		v.Check(user.DateOfBirth.Year() >= 1900, "date_of_birth", "must not be before 1900")
+	}
+	if user.GuardianEmail != "" {
+		validateGuardianEmail(v, user)
+	}
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext)
 	}
@@ -83,10 +102,10 @@ type UserModel struct {
 
 func (m UserModel) Insert(user *User) error {
 	query := `
-		INSERT INTO users (name, email, password_hash, activated)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO users (name, email, password_hash, activated, date_of_birth, guardian_email)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id, created_at, version`
-	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
+	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated, user.DateOfBirth, user.GuardianEmail}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
@@ -101,9 +120,43 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT id, created_at, name, email, password_hash, activated, version, date_of_birth, guardian_email, consented_at
+FROM users
+WHERE id = $1`
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.DateOfBirth,
+		&user.GuardianEmail,
+		&user.ConsentedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-SELECT id, created_at, name, email, password_hash, activated, version
+SELECT id, created_at, name, email, password_hash, activated, version, date_of_birth, guardian_email, consented_at
 FROM users
 WHERE email = $1`
 	var user User
@@ -117,6 +170,9 @@ WHERE email = $1`
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.DateOfBirth,
+		&user.GuardianEmail,
+		&user.ConsentedAt,
 	)
 	if err != nil {
 		switch {
@@ -132,14 +188,18 @@ WHERE email = $1`
 func (m UserModel) Update(user *User) error {
 	query := `
 UPDATE users
-SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-WHERE id = $5 AND version = $6
+SET name = $1, email = $2, password_hash = $3, activated = $4, date_of_birth = $5, guardian_email = $6,
+    consented_at = $7, version = version + 1
+WHERE id = $8 AND version = $9
 RETURNING version`
 	args := []interface{}{
 		user.Name,
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.DateOfBirth,
+		user.GuardianEmail,
+		user.ConsentedAt,
 		user.ID,
 		user.Version,
 	}
@@ -150,7 +210,6 @@ RETURNING version`
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
-			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
@@ -162,7 +221,8 @@ RETURNING version`
 func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	query := `
-SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version,
+       users.date_of_birth, users.guardian_email, users.consented_at
 FROM users
 INNER JOIN tokens
 ON users.id = tokens.user_id
@@ -181,6 +241,9 @@ AND tokens.expiry > $3`
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.DateOfBirth,
+		&user.GuardianEmail,
+		&user.ConsentedAt,
 	)
 	if err != nil {
 		switch {
@@ -192,3 +255,113 @@ AND tokens.expiry > $3`
 	}
 	return &user, nil
 }
+
+// GetAll lists users whose name or email contains search, which may be empty.
+func (m UserModel) GetAll(search string, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, name, email, activated, version, date_of_birth, guardian_email, consented_at
+FROM users
+WHERE ($1 = '' OR strpos(lower(name), lower($1)) > 0 OR strpos(lower(email), lower($1)) > 0)
+ORDER BY %s %s, id ASC
+LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, search, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Activated,
+			&user.Version,
+			&user.DateOfBirth,
+			&user.GuardianEmail,
+			&user.ConsentedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
+func (m UserModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+DELETE FROM users
+WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// SetPendingEmail records the address the user wants to change to. It only
+// replaces the user's email once confirmed through a token sent to it.
+func (m UserModel) SetPendingEmail(userID int64, email string) error {
+	query := `
+INSERT INTO users_pending_emails (user_id, email)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET email = EXCLUDED.email, created_at = NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, email)
+	return err
+}
+
+func (m UserModel) GetPendingEmail(userID int64) (string, error) {
+	query := `
+SELECT email
+FROM users_pending_emails
+WHERE user_id = $1`
+	var email string
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+	return email, nil
+}
+
+func (m UserModel) DeletePendingEmail(userID int64) error {
+	query := `
+DELETE FROM users_pending_emails
+WHERE user_id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
@@ -58,7 +58,6 @@ func (l *Logger) PrintFatal(err error, properties map[string]string) {
 func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
 	if level < l.minLevel {
 		return 0, nil
-		return 0, nil
 	}
 	aux := struct {
 		Level      string            `json:"level"`
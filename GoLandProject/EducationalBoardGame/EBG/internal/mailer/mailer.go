@@ -8,6 +8,7 @@ import (
 	"time"
 )
 
+//go:embed "templates"
 var templateFS embed.FS
 
 type Mailer struct {
@@ -35,7 +36,6 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 	}
 	plainBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
-	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,223 @@
+package oidc_test
+
+import (
+	"EBG.IssataySheg.net/internal/oidc"
+	"EBG.IssataySheg.net/internal/oidc/oidctest"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const redirectURL = "https://app.example/callback"
+
+func newProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	t.Helper()
+	srv, err := oidctest.NewServer("ebg", "s3cret/with+symbols")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	srv.SetUser(oidctest.User{Subject: "alice", Email: "alice@example.com", EmailVerified: true, Name: "Alice"})
+	return srv, oidc.NewProvider(srv.Config(redirectURL))
+}
+
+// authorize sends the user to the provider with the given nonce and returns
+// the code it redirects back with, along with the PKCE verifier.
+func authorize(t *testing.T, srv *oidctest.Server, p *oidc.Provider, nonce string) (code, verifier string) {
+	t.Helper()
+	state, err := oidc.RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err = oidc.RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(authURL, verifier) {
+		t.Fatal("authorization URL contains the PKCE verifier")
+	}
+	code, gotState, err := srv.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotState != state {
+		t.Fatalf("got state %q; want %q", gotState, state)
+	}
+	return code, verifier
+}
+
+func TestExchange(t *testing.T) {
+	srv, p := newProvider(t)
+	code, verifier := authorize(t, srv, p, "nonce")
+	claims, err := p.Exchange(context.Background(), code, "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "alice" || claims.Email != "alice@example.com" || !claims.EmailVerified || claims.Name != "Alice" {
+		t.Errorf("got claims %+v", claims)
+	}
+}
+
+func TestExchangeRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		exchange func(p *oidc.Provider, code, verifier string) error
+		want     error
+	}{
+		{"nonce mismatch", func(p *oidc.Provider, code, verifier string) error {
+			_, err := p.Exchange(context.Background(), code, "another nonce", verifier)
+			return err
+		}, oidc.ErrInvalidToken},
+		{"wrong verifier", func(p *oidc.Provider, code, verifier string) error {
+			_, err := p.Exchange(context.Background(), code, "nonce", verifier+"x")
+			return err
+		}, oidc.ErrExchange},
+		{"unknown code", func(p *oidc.Provider, code, verifier string) error {
+			_, err := p.Exchange(context.Background(), code+"x", "nonce", verifier)
+			return err
+		}, oidc.ErrExchange},
+		{"code used twice", func(p *oidc.Provider, code, verifier string) error {
+			_, err := p.Exchange(context.Background(), code, "nonce", verifier)
+			if err != nil {
+				return err
+			}
+			_, err = p.Exchange(context.Background(), code, "nonce", verifier)
+			return err
+		}, oidc.ErrExchange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, p := newProvider(t)
+			code, verifier := authorize(t, srv, p, "nonce")
+			err := tt.exchange(p, code, verifier)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v; want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExchangeWrongClientSecret(t *testing.T) {
+	srv, _ := newProvider(t)
+	config := srv.Config(redirectURL)
+	config.ClientSecret = "wrong"
+	p := oidc.NewProvider(config)
+	code, verifier := authorize(t, srv, p, "nonce")
+	_, err := p.Exchange(context.Background(), code, "nonce", verifier)
+	if !errors.Is(err, oidc.ErrExchange) {
+		t.Errorf("got error %v; want ErrExchange", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	srv, p := newProvider(t)
+	other, err := oidctest.NewServer(srv.ClientID, srv.ClientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	now := time.Now()
+	valid := func() oidc.Claims {
+		return oidc.Claims{
+			Issuer:   srv.URL,
+			Subject:  "alice",
+			Audience: []string{srv.ClientID},
+			Expiry:   now.Add(5 * time.Minute).Unix(),
+			IssuedAt: now.Unix(),
+		}
+	}
+	tests := []struct {
+		name  string
+		token func() (string, error)
+		want  error
+	}{
+		{"valid", func() (string, error) {
+			return srv.Sign(valid())
+		}, nil},
+		{"one of several audiences", func() (string, error) {
+			claims := valid()
+			claims.Audience = []string{"another client", srv.ClientID}
+			return srv.Sign(claims)
+		}, nil},
+		{"within clock leeway", func() (string, error) {
+			claims := valid()
+			claims.Expiry = now.Add(-30 * time.Second).Unix()
+			return srv.Sign(claims)
+		}, nil},
+		{"wrong issuer", func() (string, error) {
+			claims := valid()
+			claims.Issuer = other.URL
+			return srv.Sign(claims)
+		}, oidc.ErrInvalidToken},
+		{"wrong audience", func() (string, error) {
+			claims := valid()
+			claims.Audience = []string{"another client"}
+			return srv.Sign(claims)
+		}, oidc.ErrInvalidToken},
+		{"no subject", func() (string, error) {
+			claims := valid()
+			claims.Subject = ""
+			return srv.Sign(claims)
+		}, oidc.ErrInvalidToken},
+		{"expired", func() (string, error) {
+			claims := valid()
+			claims.Expiry = now.Add(-5 * time.Minute).Unix()
+			return srv.Sign(claims)
+		}, oidc.ErrInvalidToken},
+		{"issued in the future", func() (string, error) {
+			claims := valid()
+			claims.IssuedAt = now.Add(5 * time.Minute).Unix()
+			return srv.Sign(claims)
+		}, oidc.ErrInvalidToken},
+		{"signed with another key", func() (string, error) {
+			return other.Sign(valid())
+		}, oidc.ErrInvalidToken},
+		{"tampered claims", func() (string, error) {
+			token, err := srv.Sign(valid())
+			if err != nil {
+				return "", err
+			}
+			claims := valid()
+			claims.Subject = "mallory"
+			forged, err := srv.Sign(claims)
+			if err != nil {
+				return "", err
+			}
+			parts, forgedParts := strings.Split(token, "."), strings.Split(forged, ".")
+			return parts[0] + "." + forgedParts[1] + "." + parts[2], nil
+		}, oidc.ErrInvalidToken},
+		{"unsigned", func() (string, error) {
+			token, err := srv.Sign(valid())
+			if err != nil {
+				return "", err
+			}
+			header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"oidctest"}`))
+			return header + "." + strings.Split(token, ".")[1] + ".", nil
+		}, oidc.ErrInvalidToken},
+		{"malformed", func() (string, error) {
+			return "not.a-token", nil
+		}, oidc.ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.token()
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := p.Verify(context.Background(), token)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v; want %v", err, tt.want)
+			}
+			if err == nil && claims.Subject != "alice" {
+				t.Errorf("got subject %q; want alice", claims.Subject)
+			}
+		})
+	}
+}
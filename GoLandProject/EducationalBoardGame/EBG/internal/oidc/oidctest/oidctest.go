@@ -0,0 +1,255 @@
+// Package oidctest runs an in-process OpenID provider, so that the OIDC login
+// flow can be exercised without a real identity provider. The provider signs
+// every user in without asking: whoever was last set with SetUser is the user
+// an authorization request is granted for.
+package oidctest
+
+import (
+	"EBG.IssataySheg.net/internal/oidc"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const keyID = "oidctest"
+
+// User is the identity the provider signs in as.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Birthdate     string
+}
+
+type grant struct {
+	user        User
+	redirectURI string
+	nonce       string
+	challenge   string
+	expiry      time.Time
+}
+
+type Server struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	user  User
+	codes map[string]grant
+}
+
+// NewServer starts a provider which accepts a single client. Call Close when
+// done with it.
+func NewServer(clientID, clientSecret string) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		codes:        make(map[string]grant),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discoveryHandler)
+	mux.HandleFunc("/authorize", s.authorizeHandler)
+	mux.HandleFunc("/token", s.tokenHandler)
+	mux.HandleFunc("/jwks", s.jwksHandler)
+	s.Server = httptest.NewServer(mux)
+	return s, nil
+}
+
+// Config returns the configuration for a client of this provider.
+func (s *Server) Config(redirectURL string) oidc.Config {
+	return oidc.Config{
+		Issuer:       s.URL,
+		ClientID:     s.ClientID,
+		ClientSecret: s.ClientSecret,
+		RedirectURL:  redirectURL,
+	}
+}
+
+func (s *Server) SetUser(user User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = user
+}
+
+// Authorize plays the part of the browser: it follows an authorization URL and
+// returns the code and state the provider redirects back with.
+func (s *Server) Authorize(authURL string) (code, state string, err error) {
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		return "", "", fmt.Errorf("oidctest: authorize returned %s", res.Status)
+	}
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+	query := location.Query()
+	if e := query.Get("error"); e != "" {
+		return "", "", errors.New("oidctest: " + e)
+	}
+	return query.Get("code"), query.Get("state"), nil
+}
+
+func (s *Server) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.URL,
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"jwks_uri":                              s.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *Server) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("client_id") != s.ClientID {
+		http.Error(w, "unknown client or redirect_uri", http.StatusBadRequest)
+		return
+	}
+	reply := redirectURI.Query()
+	reply.Set("state", query.Get("state"))
+	switch {
+	case query.Get("response_type") != "code":
+		reply.Set("error", "unsupported_response_type")
+	case query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "":
+		reply.Set("error", "invalid_request")
+	default:
+		code, err := oidc.RandomString()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.mu.Lock()
+		s.codes[code] = grant{
+			user:        s.user,
+			redirectURI: redirectURI.String(),
+			nonce:       query.Get("nonce"),
+			challenge:   query.Get("code_challenge"),
+			expiry:      time.Now().Add(time.Minute),
+		}
+		s.mu.Unlock()
+		reply.Set("code", code)
+	}
+	redirectURI.RawQuery = reply.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *Server) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, secret, _ := r.BasicAuth()
+	id, _ = url.QueryUnescape(id)
+	secret, _ = url.QueryUnescape(secret)
+	if id != s.ClientID || secret != s.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	s.mu.Lock()
+	g, ok := s.codes[r.PostFormValue("code")]
+	delete(s.codes, r.PostFormValue("code"))
+	s.mu.Unlock()
+	if !ok || time.Now().After(g.expiry) || g.redirectURI != r.PostFormValue("redirect_uri") ||
+		oidc.Challenge(r.PostFormValue("code_verifier")) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	idToken, err := s.Sign(oidc.Claims{
+		Issuer:        s.URL,
+		Subject:       g.user.Subject,
+		Audience:      []string{s.ClientID},
+		Expiry:        time.Now().Add(5 * time.Minute).Unix(),
+		IssuedAt:      time.Now().Unix(),
+		Nonce:         g.nonce,
+		Email:         g.user.Email,
+		EmailVerified: g.user.EmailVerified,
+		Name:          g.user.Name,
+		Birthdate:     g.user.Birthdate,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "oidctest",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (s *Server) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	pub := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// Sign returns the claims as a JWT signed with the provider's key, for
+// testing how clients handle tokens the provider would never issue.
+func (s *Server) Sign(claims oidc.Claims) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,214 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+)
+
+const (
+	raceFinish    = 30
+	raceQuizBonus = 2
+)
+
+var (
+	raceJumps = map[int]int{
+		3:  11,
+		8:  16,
+		14: 22,
+		19: 27,
+		17: 7,
+		24: 12,
+		29: 20,
+	}
+	raceQuizSquares = map[int]bool{5: true, 10: true, 15: true, 21: true, 25: true}
+)
+
+// Race is a snakes-and-ladders style race to square 30. Landing on a quiz
+// square poses an arithmetic question: a correct answer moves the player
+// forward two squares and a wrong one moves them back two. The server rolls
+// the die, so clients cannot choose their own rolls.
+type Race struct {
+	roll func() int
+}
+
+// NewRace returns the race engine. roll returns a die roll from 1 to 6 and
+// defaults to a random roll when nil.
+func NewRace(roll func() int) *Race {
+	if roll == nil {
+		roll = func() int { return rand.Intn(6) + 1 }
+	}
+	return &Race{roll: roll}
+}
+
+type raceState struct {
+	Players   []int64       `json:"players"`
+	Positions []int         `json:"positions"`
+	Turn      int           `json:"turn"`
+	LastRoll  int           `json:"last_roll,omitempty"`
+	Question  *raceQuestion `json:"question,omitempty"`
+	Winner    int64         `json:"winner,omitempty"`
+}
+
+type raceQuestion struct {
+	Prompt string `json:"prompt"`
+	A      int    `json:"a"`
+	B      int    `json:"b"`
+	Op     string `json:"op"`
+}
+
+func (q raceQuestion) answer() int {
+	switch q.Op {
+	case "+":
+		return q.A + q.B
+	case "-":
+		return q.A - q.B
+	default:
+		return q.A * q.B
+	}
+}
+
+type raceMove struct {
+	Action string `json:"action"`
+	Answer *int   `json:"answer,omitempty"`
+}
+
+func (e *Race) Name() string {
+	return "race"
+}
+
+func (e *Race) NewState(players []int64) (State, error) {
+	if len(players) < 1 || len(players) > 6 {
+		return nil, fmt.Errorf("%w: race needs between 1 and 6 players", ErrWrongPlayerCount)
+	}
+	return json.Marshal(raceState{
+		Players:   players,
+		Positions: make([]int, len(players)),
+	})
+}
+
+func (e *Race) CurrentPlayer(state State) (int64, error) {
+	s, err := decodeRace(state)
+	if err != nil {
+		return 0, err
+	}
+	if s.Winner != 0 {
+		return 0, nil
+	}
+	return s.Players[s.Turn], nil
+}
+
+func (e *Race) LegalMoves(state State, player int64) ([]Move, error) {
+	s, err := decodeRace(state)
+	if err != nil {
+		return nil, err
+	}
+	if s.Winner != 0 || s.Players[s.Turn] != player {
+		return []Move{}, nil
+	}
+	if s.Question != nil {
+		return []Move{Move(`{"action":"answer","answer":0}`)}, nil
+	}
+	return []Move{Move(`{"action":"roll"}`)}, nil
+}
+
+func (e *Race) Apply(state State, player int64, move Move) (State, error) {
+	s, err := decodeRace(state)
+	if err != nil {
+		return nil, err
+	}
+	var m raceMove
+	if err := decode(move, &m); err != nil {
+		return nil, err
+	}
+	switch {
+	case s.Winner != 0:
+		return nil, ErrGameOver
+	case s.Players[s.Turn] != player:
+		return nil, ErrNotYourTurn
+	}
+	switch {
+	case m.Action == "roll" && s.Question == nil:
+		s.LastRoll = e.roll()
+		s.Positions[s.Turn] = raceAdvance(s.Positions[s.Turn], s.LastRoll)
+		if raceQuizSquares[s.Positions[s.Turn]] {
+			// The player keeps the turn until they answer.
+			s.Question = newRaceQuestion()
+			return json.Marshal(s)
+		}
+	case m.Action == "answer" && s.Question != nil:
+		if m.Answer == nil {
+			return nil, fmt.Errorf("%w: an answer must be provided", ErrIllegalMove)
+		}
+		if *m.Answer == s.Question.answer() {
+			s.Positions[s.Turn] = raceAdvance(s.Positions[s.Turn], raceQuizBonus)
+		} else {
+			s.Positions[s.Turn] = raceAdvance(s.Positions[s.Turn], -raceQuizBonus)
+		}
+		s.Question = nil
+	case m.Action == "roll" || m.Action == "answer":
+		return nil, fmt.Errorf("%w: cannot %s now", ErrIllegalMove, m.Action)
+	default:
+		return nil, fmt.Errorf("%w: action must be roll or answer", ErrIllegalMove)
+	}
+	if s.Positions[s.Turn] >= raceFinish {
+		s.Winner = player
+	} else {
+		s.Turn = (s.Turn + 1) % len(s.Players)
+	}
+	return json.Marshal(s)
+}
+
+func (e *Race) Winner(state State) (int64, bool, error) {
+	s, err := decodeRace(state)
+	if err != nil {
+		return 0, false, err
+	}
+	return s.Winner, s.Winner != 0, nil
+}
+
+// decodeRace decodes a state, checking that it is one this engine could have
+// produced.
+func decodeRace(state State) (raceState, error) {
+	var s raceState
+	if err := json.Unmarshal(state, &s); err != nil {
+		return s, fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+	if len(s.Players) == 0 || len(s.Positions) != len(s.Players) || s.Turn < 0 || s.Turn >= len(s.Players) {
+		return s, fmt.Errorf("%w: not a race game", ErrInvalidState)
+	}
+	return s, nil
+}
+
+// raceAdvance moves a token by steps, following any ladder or snake at the
+// square it lands on and never going back past the start.
+func raceAdvance(position, steps int) int {
+	position += steps
+	if position < 0 {
+		position = 0
+	}
+	if position >= raceFinish {
+		return raceFinish
+	}
+	if to, ok := raceJumps[position]; ok {
+		position = to
+	}
+	return position
+}
+
+func newRaceQuestion() *raceQuestion {
+	q := &raceQuestion{A: rand.Intn(10) + 1, B: rand.Intn(10) + 1}
+	switch rand.Intn(3) {
+	case 0:
+		q.Op = "+"
+	case 1:
+		q.Op = "-"
+		if q.B > q.A {
+			q.A, q.B = q.B, q.A
+		}
+	default:
+		q.Op = "*"
+	}
+	q.Prompt = fmt.Sprintf("%d %s %d", q.A, q.Op, q.B)
+	return q
+}
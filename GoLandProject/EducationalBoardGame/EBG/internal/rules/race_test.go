@@ -0,0 +1,114 @@
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// rolls returns a die that rolls the given values in turn.
+func rolls(values ...int) func() int {
+	return func() int {
+		roll := values[0]
+		values = values[1:]
+		return roll
+	}
+}
+
+func TestRaceApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		state  string
+		roll   int
+		player int64
+		move   string
+		want   error
+	}{
+		{"roll", `{"players":[1,2],"positions":[0,0]}`, 1, 1, `{"action":"roll"}`, nil},
+		{"second player rolls", `{"players":[1,2],"positions":[1,0],"turn":1}`, 1, 2, `{"action":"roll"}`, nil},
+		{"second player first", `{"players":[1,2],"positions":[0,0]}`, 1, 2, `{"action":"roll"}`, ErrNotYourTurn},
+		{"same player twice", `{"players":[1,2],"positions":[1,0],"turn":1}`, 1, 1, `{"action":"roll"}`, ErrNotYourTurn},
+		{"not a player", `{"players":[1,2],"positions":[0,0]}`, 1, 3, `{"action":"roll"}`, ErrNotYourTurn},
+		{"answer without a question", `{"players":[1,2],"positions":[0,0]}`, 1, 1, `{"action":"answer","answer":3}`, ErrIllegalMove},
+		{"roll with a question pending", `{"players":[1,2],"positions":[5,0],"question":{"a":1,"b":2,"op":"+"}}`, 1, 1, `{"action":"roll"}`, ErrIllegalMove},
+		{"answer missing", `{"players":[1,2],"positions":[5,0],"question":{"a":1,"b":2,"op":"+"}}`, 1, 1, `{"action":"answer"}`, ErrIllegalMove},
+		{"answer", `{"players":[1,2],"positions":[5,0],"question":{"a":1,"b":2,"op":"+"}}`, 1, 1, `{"action":"answer","answer":3}`, nil},
+		{"unknown action", `{"players":[1,2],"positions":[0,0]}`, 1, 1, `{"action":"jump"}`, ErrIllegalMove},
+		{"malformed move", `{"players":[1,2],"positions":[0,0]}`, 1, 1, `{"action":`, ErrIllegalMove},
+		{"after a win", `{"players":[1,2],"positions":[30,4],"turn":0,"winner":1}`, 1, 2, `{"action":"roll"}`, ErrGameOver},
+		{"winner rolls again", `{"players":[1,2],"positions":[30,4],"turn":0,"winner":1}`, 1, 1, `{"action":"roll"}`, ErrGameOver},
+		{"empty state", `{}`, 1, 1, `{"action":"roll"}`, ErrInvalidState},
+		{"turn out of range", `{"players":[1,2],"positions":[0,0],"turn":2}`, 1, 1, `{"action":"roll"}`, ErrInvalidState},
+		{"positions missing", `{"players":[1,2]}`, 1, 1, `{"action":"roll"}`, ErrInvalidState},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRace(rolls(tt.roll)).Apply(State(tt.state), tt.player, Move(tt.move))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v; want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRaceGame(t *testing.T) {
+	e := NewRace(rolls(3, 1, 4, 2))
+	state, err := e.NewState([]int64{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	play := func(player int64, move string, position int) {
+		t.Helper()
+		state, err = e.Apply(state, player, Move(move))
+		if err != nil {
+			t.Fatalf("player %d %s: %v", player, move, err)
+		}
+		var s raceState
+		if err := json.Unmarshal(state, &s); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.Positions[seatOf(s.Players, player)]; got != position {
+			t.Fatalf("player %d %s: got position %d; want %d", player, move, got, position)
+		}
+	}
+	current := func(want int64) {
+		t.Helper()
+		got, err := e.CurrentPlayer(state)
+		if err != nil || got != want {
+			t.Fatalf("got current player %d, error %v; want %d, nil", got, err, want)
+		}
+	}
+
+	// Player 1 climbs the ladder at 3 and player 2 moves to 1.
+	play(1, `{"action":"roll"}`, 11)
+	play(2, `{"action":"roll"}`, 1)
+	// Landing on the quiz square at 15 keeps the turn until it is answered.
+	play(1, `{"action":"roll"}`, 15)
+	current(1)
+	var s raceState
+	if err := json.Unmarshal(state, &s); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := json.Marshal(raceMove{Action: "answer", Answer: func(n int) *int { return &n }(s.Question.answer())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The right answer moves on two squares, onto the snake at 17.
+	play(1, string(answer), 7)
+	current(2)
+	if _, err := e.Apply(state, 1, Move(`{"action":"roll"}`)); !errors.Is(err, ErrNotYourTurn) {
+		t.Fatalf("got error %v; want ErrNotYourTurn", err)
+	}
+
+	state = State(`{"players":[1,2],"positions":[28,4],"turn":0}`)
+	play(1, `{"action":"roll"}`, 30)
+	winner, over, err := e.Winner(state)
+	if err != nil || !over || winner != 1 {
+		t.Fatalf("got winner %d, over %t, error %v; want 1, true, nil", winner, over, err)
+	}
+	current(0)
+	moves, err := e.LegalMoves(state, 2)
+	if err != nil || len(moves) != 0 {
+		t.Errorf("got %d legal moves, error %v; want none", len(moves), err)
+	}
+}
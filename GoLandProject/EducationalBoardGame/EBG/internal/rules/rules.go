@@ -0,0 +1,117 @@
+// Package rules defines the interface that turn-based board games implement so
+// that play sessions can be refereed by the server, along with a registry that
+// maps catalog games onto their rules.
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrIllegalMove      = errors.New("illegal move")
+	ErrNotYourTurn      = errors.New("it is not your turn")
+	ErrGameOver         = errors.New("the game is over")
+	ErrWrongPlayerCount = errors.New("wrong number of players")
+	ErrUnknownEngine    = errors.New("unknown rules engine")
+	ErrInvalidState     = errors.New("invalid game state")
+)
+
+// State and Move are JSON documents whose shape is defined by each Engine.
+// States are sent to every player in the session, so they must not contain
+// anything that players should not see.
+type (
+	State = json.RawMessage
+	Move  = json.RawMessage
+)
+
+// Engine implements the rules of a turn-based game. Engines must be safe for
+// concurrent use; all per-game data lives in the State they return. Methods
+// given a state the engine could not have produced return an error wrapping
+// ErrInvalidState.
+type Engine interface {
+	// Name identifies the engine in configuration.
+	Name() string
+	// NewState sets up a game for the given players, in seating order.
+	NewState(players []int64) (State, error)
+	// CurrentPlayer returns the player whose turn it is, or zero once the
+	// game is over.
+	CurrentPlayer(state State) (int64, error)
+	// LegalMoves lists the moves that player may make. It is empty when it
+	// is not their turn.
+	LegalMoves(state State, player int64) ([]Move, error)
+	// Apply validates and makes player's move, returning the new state.
+	// Errors caused by the move wrap ErrIllegalMove, ErrNotYourTurn or
+	// ErrGameOver.
+	Apply(state State, player int64, move Move) (State, error)
+	// Winner reports whether the game is over and who won it. A finished
+	// game with a zero winner is a draw.
+	Winner(state State) (winner int64, over bool, err error)
+}
+
+var builtins = map[string]func() Engine{
+	"race":      func() Engine { return NewRace(nil) },
+	"tictactoe": func() Engine { return NewTicTacToe() },
+}
+
+// New returns a new instance of the built-in engine with the given name.
+func New(name string) (Engine, error) {
+	constructor, ok := builtins[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownEngine, name)
+	}
+	return constructor(), nil
+}
+
+// Names lists the built-in engines.
+func Names() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Registry maps catalog game IDs onto the engines that referee them.
+type Registry struct {
+	mu      sync.RWMutex
+	engines map[int64]Engine
+}
+
+func NewRegistry() *Registry {
+	return &Registry{engines: make(map[int64]Engine)}
+}
+
+func (r *Registry) Register(gameID int64, engine Engine) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.engines[gameID] = engine
+}
+
+func (r *Registry) Lookup(gameID int64) (Engine, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	engine, ok := r.engines[gameID]
+	return engine, ok
+}
+
+func decode(data json.RawMessage, v interface{}) error {
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrIllegalMove, "malformed JSON")
+	}
+	return nil
+}
+
+func seatOf(players []int64, player int64) int {
+	for i := range players {
+		if players[i] == player {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,176 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const tictactoeTarget = 15
+
+var tictactoeLines = [8][3]int{
+	{0, 1, 2}, {3, 4, 5}, {6, 7, 8},
+	{0, 3, 6}, {1, 4, 7}, {2, 5, 8},
+	{0, 4, 8}, {2, 4, 6},
+}
+
+// TicTacToe is numerical tic-tac-toe. The first player places the odd numbers
+// 1 to 9 and the second the even numbers, each number at most once, and the
+// first to complete a line of three numbers adding up to 15 wins.
+type TicTacToe struct{}
+
+func NewTicTacToe() *TicTacToe {
+	return &TicTacToe{}
+}
+
+type tictactoeState struct {
+	Players []int64 `json:"players"`
+	Board   [9]int  `json:"board"`
+	Turn    int     `json:"turn"`
+	Winner  int64   `json:"winner,omitempty"`
+	Draw    bool    `json:"draw,omitempty"`
+}
+
+type tictactoeMove struct {
+	Cell   int `json:"cell"`
+	Number int `json:"number"`
+}
+
+func (e *TicTacToe) Name() string {
+	return "tictactoe"
+}
+
+func (e *TicTacToe) NewState(players []int64) (State, error) {
+	if len(players) != 2 {
+		return nil, fmt.Errorf("%w: tic-tac-toe needs exactly 2 players", ErrWrongPlayerCount)
+	}
+	return json.Marshal(tictactoeState{Players: players})
+}
+
+func (e *TicTacToe) CurrentPlayer(state State) (int64, error) {
+	s, err := decodeTicTacToe(state)
+	if err != nil {
+		return 0, err
+	}
+	if s.Winner != 0 || s.Draw {
+		return 0, nil
+	}
+	return s.Players[s.Turn], nil
+}
+
+func (e *TicTacToe) LegalMoves(state State, player int64) ([]Move, error) {
+	s, err := decodeTicTacToe(state)
+	if err != nil {
+		return nil, err
+	}
+	moves := []Move{}
+	if s.Winner != 0 || s.Draw || s.Players[s.Turn] != player {
+		return moves, nil
+	}
+	for cell, value := range s.Board {
+		if value != 0 {
+			continue
+		}
+		for number := 1 + s.Turn; number <= 9; number += 2 {
+			if s.used(number) {
+				continue
+			}
+			move, err := json.Marshal(tictactoeMove{Cell: cell, Number: number})
+			if err != nil {
+				return nil, err
+			}
+			moves = append(moves, move)
+		}
+	}
+	return moves, nil
+}
+
+func (e *TicTacToe) Apply(state State, player int64, move Move) (State, error) {
+	s, err := decodeTicTacToe(state)
+	if err != nil {
+		return nil, err
+	}
+	var m tictactoeMove
+	if err := decode(move, &m); err != nil {
+		return nil, err
+	}
+	switch {
+	case s.Winner != 0 || s.Draw:
+		return nil, ErrGameOver
+	case seatOf(s.Players, player) != s.Turn:
+		return nil, ErrNotYourTurn
+	case m.Cell < 0 || m.Cell > 8:
+		return nil, fmt.Errorf("%w: cell must be between 0 and 8", ErrIllegalMove)
+	case s.Board[m.Cell] != 0:
+		return nil, fmt.Errorf("%w: cell %d is already taken", ErrIllegalMove, m.Cell)
+	case m.Number < 1 || m.Number > 9 || m.Number%2 != (s.Turn+1)%2:
+		if s.Turn == 0 {
+			return nil, fmt.Errorf("%w: you must play an odd number from 1 to 9", ErrIllegalMove)
+		}
+		return nil, fmt.Errorf("%w: you must play an even number from 2 to 8", ErrIllegalMove)
+	case s.used(m.Number):
+		return nil, fmt.Errorf("%w: %d has already been played", ErrIllegalMove, m.Number)
+	}
+	s.Board[m.Cell] = m.Number
+	switch {
+	case s.completesLine(m.Cell):
+		s.Winner = player
+	case s.full():
+		s.Draw = true
+	default:
+		s.Turn = 1 - s.Turn
+	}
+	return json.Marshal(s)
+}
+
+func (e *TicTacToe) Winner(state State) (int64, bool, error) {
+	s, err := decodeTicTacToe(state)
+	if err != nil {
+		return 0, false, err
+	}
+	return s.Winner, s.Winner != 0 || s.Draw, nil
+}
+
+// decodeTicTacToe decodes a state, checking that it is one this engine could
+// have produced, since sessions may hold states from before the game had an
+// engine.
+func decodeTicTacToe(state State) (tictactoeState, error) {
+	var s tictactoeState
+	if err := json.Unmarshal(state, &s); err != nil {
+		return s, fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+	if len(s.Players) != 2 || s.Turn < 0 || s.Turn > 1 {
+		return s, fmt.Errorf("%w: not a tic-tac-toe game", ErrInvalidState)
+	}
+	return s, nil
+}
+
+func (s tictactoeState) used(number int) bool {
+	for _, value := range s.Board {
+		if value == number {
+			return true
+		}
+	}
+	return false
+}
+
+func (s tictactoeState) full() bool {
+	for _, value := range s.Board {
+		if value == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (s tictactoeState) completesLine(cell int) bool {
+	for _, line := range tictactoeLines {
+		if line[0] != cell && line[1] != cell && line[2] != cell {
+			continue
+		}
+		a, b, c := s.Board[line[0]], s.Board[line[1]], s.Board[line[2]]
+		if a != 0 && b != 0 && c != 0 && a+b+c == tictactoeTarget {
+			return true
+		}
+	}
+	return false
+}
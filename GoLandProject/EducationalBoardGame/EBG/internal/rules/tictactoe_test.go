@@ -0,0 +1,127 @@
+package rules
+
+import (
+	"errors"
+	"testing"
+)
+
+// playTicTacToe starts a game between players 1 and 2 and makes the given
+// moves, which alternate between them and must all be legal.
+func playTicTacToe(t *testing.T, moves ...string) State {
+	t.Helper()
+	e := NewTicTacToe()
+	state, err := e.NewState([]int64{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, move := range moves {
+		state, err = e.Apply(state, int64(i%2+1), Move(move))
+		if err != nil {
+			t.Fatalf("move %d %s: %v", i, move, err)
+		}
+	}
+	return state
+}
+
+func TestTicTacToeApply(t *testing.T) {
+	// Player 1 completes the top row with 9 + 5 + 1 on their third move.
+	won := []string{`{"cell":0,"number":9}`, `{"cell":8,"number":2}`, `{"cell":1,"number":5}`, `{"cell":7,"number":4}`, `{"cell":2,"number":1}`}
+	tests := []struct {
+		name   string
+		played []string
+		player int64
+		move   string
+		want   error
+	}{
+		{"first move", nil, 1, `{"cell":4,"number":5}`, nil},
+		{"reply", won[:1], 2, `{"cell":4,"number":6}`, nil},
+		{"second player first", nil, 2, `{"cell":4,"number":2}`, ErrNotYourTurn},
+		{"same player twice", won[:1], 1, `{"cell":4,"number":3}`, ErrNotYourTurn},
+		{"not a player", nil, 3, `{"cell":4,"number":5}`, ErrNotYourTurn},
+		{"cell below range", nil, 1, `{"cell":-1,"number":5}`, ErrIllegalMove},
+		{"cell above range", nil, 1, `{"cell":9,"number":5}`, ErrIllegalMove},
+		{"cell taken", won[:1], 2, `{"cell":0,"number":2}`, ErrIllegalMove},
+		{"even number for first player", nil, 1, `{"cell":4,"number":2}`, ErrIllegalMove},
+		{"odd number for second player", won[:1], 2, `{"cell":4,"number":3}`, ErrIllegalMove},
+		{"number out of range", nil, 1, `{"cell":4,"number":11}`, ErrIllegalMove},
+		{"number already played", won[:2], 1, `{"cell":4,"number":9}`, ErrIllegalMove},
+		{"malformed move", nil, 1, `{"cell":`, ErrIllegalMove},
+		{"after a win", won, 2, `{"cell":4,"number":6}`, ErrGameOver},
+		{"winner moves again", won, 1, `{"cell":4,"number":3}`, ErrGameOver},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := playTicTacToe(t, tt.played...)
+			_, err := NewTicTacToe().Apply(state, tt.player, Move(tt.move))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v; want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTicTacToeWinner(t *testing.T) {
+	e := NewTicTacToe()
+	state := playTicTacToe(t, `{"cell":0,"number":9}`, `{"cell":8,"number":2}`, `{"cell":1,"number":5}`, `{"cell":7,"number":4}`, `{"cell":2,"number":1}`)
+	winner, over, err := e.Winner(state)
+	if err != nil || !over || winner != 1 {
+		t.Fatalf("got winner %d, over %t, error %v; want 1, true, nil", winner, over, err)
+	}
+	current, err := e.CurrentPlayer(state)
+	if err != nil || current != 0 {
+		t.Errorf("got current player %d, error %v; want 0, nil", current, err)
+	}
+	moves, err := e.LegalMoves(state, 2)
+	if err != nil || len(moves) != 0 {
+		t.Errorf("got %d legal moves, error %v; want none", len(moves), err)
+	}
+}
+
+func TestTicTacToeLegalMoves(t *testing.T) {
+	e := NewTicTacToe()
+	state := playTicTacToe(t)
+	moves, err := e.LegalMoves(state, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Five odd numbers in each of nine cells.
+	if len(moves) != 45 {
+		t.Errorf("got %d legal moves for player 1; want 45", len(moves))
+	}
+	for _, move := range moves {
+		if _, err := e.Apply(state, 1, move); err != nil {
+			t.Errorf("legal move %s rejected: %v", move, err)
+		}
+	}
+	moves, err = e.LegalMoves(state, 2)
+	if err != nil || len(moves) != 0 {
+		t.Errorf("got %d legal moves for player 2, error %v; want none", len(moves), err)
+	}
+}
+
+func TestTicTacToeInvalidState(t *testing.T) {
+	e := NewTicTacToe()
+	states := []string{
+		`{}`,
+		`{"players":[1]}`,
+		`{"players":[1,2],"turn":2}`,
+		`{"players":[1,2],"turn":-1}`,
+		`[]`,
+	}
+	for _, state := range states {
+		t.Run(state, func(t *testing.T) {
+			if _, err := e.CurrentPlayer(State(state)); !errors.Is(err, ErrInvalidState) {
+				t.Errorf("CurrentPlayer: got error %v; want ErrInvalidState", err)
+			}
+			if _, err := e.LegalMoves(State(state), 1); !errors.Is(err, ErrInvalidState) {
+				t.Errorf("LegalMoves: got error %v; want ErrInvalidState", err)
+			}
+			if _, err := e.Apply(State(state), 1, Move(`{"cell":4,"number":5}`)); !errors.Is(err, ErrInvalidState) {
+				t.Errorf("Apply: got error %v; want ErrInvalidState", err)
+			}
+			if _, _, err := e.Winner(State(state)); !errors.Is(err, ErrInvalidState) {
+				t.Errorf("Winner: got error %v; want ErrInvalidState", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed from RFC 6238 Appendix B, the ASCII string
+// "12345678901234567890", base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// The RFC gives eight digit codes; six digit codes are their last six.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %q; want %q", tt.unix, got, tt.want)
+		}
+		got, err = Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", Step(time.Unix(tt.unix, 0)))
+		if err != nil || got != tt.want {
+			t.Errorf("Code with a lower case secret at %d = %q, %v; want %q", tt.unix, got, err, tt.want)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	tests := []struct {
+		name   string
+		offset int64
+		want   bool
+	}{
+		{"current step", 0, true},
+		{"previous step", -1, true},
+		{"next step", 1, true},
+		{"two steps behind", -2, false},
+		{"two steps ahead", 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step := Step(now) + tt.offset
+			code, err := Code(rfcSecret, step)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := Validate(rfcSecret, code, now)
+			if ok != tt.want {
+				t.Fatalf("Validate = %v; want %v", ok, tt.want)
+			}
+			if ok && got != step {
+				t.Errorf("Validate matched step %d; want %d", got, step)
+			}
+		})
+	}
+}
+
+func TestValidateStepBoundary(t *testing.T) {
+	// The last and first seconds of neighbouring steps both accept the code
+	// for either step, but not one two steps away.
+	end := time.Unix(89, 0)
+	start := time.Unix(90, 0)
+	for _, at := range []time.Time{end, start} {
+		for _, step := range []int64{2, 3} {
+			code, _ := Code(rfcSecret, step)
+			if _, ok := Validate(rfcSecret, code, at); !ok {
+				t.Errorf("code for step %d rejected at %d", step, at.Unix())
+			}
+		}
+	}
+	code, _ := Code(rfcSecret, 4)
+	if _, ok := Validate(rfcSecret, code, end); ok {
+		t.Errorf("code for step 4 accepted at %d", end.Unix())
+	}
+	code, _ = Code(rfcSecret, 1)
+	if _, ok := Validate(rfcSecret, code, start); ok {
+		t.Errorf("code for step 1 accepted at %d", start.Unix())
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	at := time.Unix(59, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+	}{
+		{"eight digits", rfcSecret, "94287082"},
+		{"five digits", rfcSecret, "28708"},
+		{"seven digits", rfcSecret, "2870820"},
+		{"empty", rfcSecret, ""},
+		{"letters", rfcSecret, "28708a"},
+		{"wrong code", rfcSecret, "287083"},
+		{"undecodable secret", "not base32!", "287082"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(tt.secret, tt.code, at); ok {
+				t.Errorf("Validate(%q, %q) accepted", tt.secret, tt.code)
+			}
+		})
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code accepted an undecodable secret")
+	}
+}
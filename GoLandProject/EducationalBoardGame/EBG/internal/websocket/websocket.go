@@ -0,0 +1,264 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455) on top of net/http, which is all the API needs for play sessions.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	continuationFrame = 0
+	maxMessageSize    = 64 * 1024
+	acceptGUID        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+const (
+	CloseNormalClosure   = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseMessageTooBig   = 1009
+	CloseInternalErr     = 1011
+	closeNoStatusPresent = 1005
+)
+
+var (
+	ErrBadHandshake    = errors.New("websocket: bad handshake")
+	ErrClosed          = errors.New("websocket: connection closed")
+	ErrProtocol        = errors.New("websocket: protocol error")
+	ErrMessageTooLarge = errors.New("websocket: message too large")
+)
+
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	mu   sync.Mutex
+}
+
+// Upgrade performs the opening handshake and takes over the underlying
+// connection. On failure an error response has already been sent to the client.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "invalid websocket key", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, ErrBadHandshake
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// The server's read and write timeouts still apply to the hijacked
+	// connection, so clear them; callers manage their own deadlines.
+	conn.SetDeadline(time.Time{})
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	_, err = conn.Write([]byte(response))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, br: rw.Reader}, nil
+}
+
+func acceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage returns the next text, binary or pong message. Pings are
+// answered automatically, and a close frame from the client is acknowledged
+// and reported as ErrClosed.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var (
+		messageType int
+		message     []byte
+	)
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case PingMessage:
+			err = c.WriteMessage(PongMessage, payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			return PongMessage, payload, nil
+		case CloseMessage:
+			code := closeNoStatusPresent
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.WriteClose(code, "")
+			return 0, nil, ErrClosed
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, ErrProtocol)
+			}
+			messageType = opcode
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, ErrProtocol)
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, ErrProtocol)
+		}
+		if len(message)+len(payload) > maxMessageSize {
+			return 0, nil, c.fail(CloseMessageTooBig, ErrMessageTooLarge)
+		}
+		message = append(message, payload...)
+		if fin {
+			return messageType, message, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	_, err := io.ReadFull(c.br, header[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0f)
+	if header[0]&0x70 != 0 || header[1]&0x80 == 0 {
+		// Extensions are never negotiated and clients must mask every frame.
+		return false, 0, nil, c.fail(CloseProtocolError, ErrProtocol)
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.br, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.br, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if opcode >= CloseMessage && (length > 125 || !fin) {
+		return false, 0, nil, c.fail(CloseProtocolError, ErrProtocol)
+	}
+	if length > maxMessageSize {
+		return false, 0, nil, c.fail(CloseMessageTooBig, ErrMessageTooLarge)
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *Conn) fail(code int, err error) error {
+	c.WriteClose(code, "")
+	return err
+}
+
+// WriteMessage sends payload as a single unfragmented frame. It is safe to
+// call from multiple goroutines.
+func (c *Conn) WriteMessage(opcode int, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|byte(opcode))
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *Conn) WriteJSON(v interface{}) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(TextMessage, js)
+}
+
+func (c *Conn) WriteClose(code int, reason string) error {
+	if code == closeNoStatusPresent {
+		return c.WriteMessage(CloseMessage, nil)
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return c.WriteMessage(CloseMessage, append(payload, reason...))
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
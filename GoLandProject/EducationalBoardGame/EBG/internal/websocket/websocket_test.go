@@ -0,0 +1,320 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455 section 1.3.
+	if got, want := acceptKey(testKey), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestUpgradeRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		status  int
+	}{
+		{"plain request", http.MethodGet, nil, http.StatusUpgradeRequired},
+		{"wrong method", http.MethodPost, map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": testKey}, http.StatusUpgradeRequired},
+		{"wrong version", http.MethodGet, map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": testKey}, http.StatusUpgradeRequired},
+		{"missing key", http.MethodGet, map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13"}, http.StatusBadRequest},
+		{"short key", http.MethodGet, map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "c2hvcnQ="}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			_, err := Upgrade(w, r)
+			if !errors.Is(err, ErrBadHandshake) {
+				t.Errorf("got error %v; want ErrBadHandshake", err)
+			}
+			if w.Code != tt.status {
+				t.Errorf("got status %d; want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+// client is the client end of a connection whose server end is conn.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// dial upgrades a connection to a test server and returns both its ends.
+func dial(t *testing.T) (*Conn, *client) {
+	t.Helper()
+	conns := make(chan *Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+	netConn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { netConn.Close() })
+	netConn.SetDeadline(time.Now().Add(5 * time.Second))
+	request := "GET / HTTP/1.1\r\n" +
+		"Host: test\r\n" +
+		"Connection: keep-alive, Upgrade\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: " + testKey + "\r\n\r\n"
+	if _, err := netConn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(netConn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d; want 101", res.StatusCode)
+	}
+	if got := res.Header.Get("Sec-WebSocket-Accept"); got != acceptKey(testKey) {
+		t.Fatalf("got Sec-WebSocket-Accept %q; want %q", got, acceptKey(testKey))
+	}
+	conn := <-conns
+	if conn == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn, &client{t: t, conn: netConn, br: br}
+}
+
+// writeFrame sends a frame, masked as clients are required to unless masked
+// is false.
+func (c *client) writeFrame(fin bool, opcode int, payload []byte, masked bool) {
+	c.t.Helper()
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	if masked {
+		mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// readFrame reads a frame from the server, which must not be masked.
+func (c *client) readFrame() (bool, int, []byte) {
+	c.t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	if header[1]&0x80 != 0 {
+		c.t.Fatal("server sent a masked frame")
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.br, extended[:]); err != nil {
+			c.t.Fatal(err)
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.br, extended[:]); err != nil {
+			c.t.Fatal(err)
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return header[0]&0x80 != 0, int(header[0] & 0x0f), payload
+}
+
+// expectClose reads a close frame from the server and checks its status code.
+func (c *client) expectClose(code int) {
+	c.t.Helper()
+	_, opcode, payload := c.readFrame()
+	if opcode != CloseMessage {
+		c.t.Fatalf("got opcode %d; want a close frame", opcode)
+	}
+	if len(payload) < 2 || int(binary.BigEndian.Uint16(payload)) != code {
+		c.t.Fatalf("got close payload %v; want code %d", payload, code)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	conn, c := dial(t)
+	c.writeFrame(true, TextMessage, []byte("hello"), true)
+	messageType, message, err := conn.ReadMessage()
+	if err != nil || messageType != TextMessage || string(message) != "hello" {
+		t.Fatalf("got %d %q, error %v; want text \"hello\"", messageType, message, err)
+	}
+	long := bytes.Repeat([]byte("x"), 300)
+	c.writeFrame(true, BinaryMessage, long, true)
+	messageType, message, err = conn.ReadMessage()
+	if err != nil || messageType != BinaryMessage || !bytes.Equal(message, long) {
+		t.Fatalf("got %d with %d bytes, error %v; want binary with 300 bytes", messageType, len(message), err)
+	}
+}
+
+func TestReadFragmentedMessage(t *testing.T) {
+	conn, c := dial(t)
+	c.writeFrame(false, TextMessage, []byte("hel"), true)
+	// Control frames may be interleaved with the fragments.
+	c.writeFrame(true, PingMessage, []byte("ping"), true)
+	c.writeFrame(false, continuationFrame, []byte("lo, "), true)
+	c.writeFrame(true, continuationFrame, []byte("world"), true)
+	messageType, message, err := conn.ReadMessage()
+	if err != nil || messageType != TextMessage || string(message) != "hello, world" {
+		t.Fatalf("got %d %q, error %v; want text \"hello, world\"", messageType, message, err)
+	}
+	_, opcode, payload := c.readFrame()
+	if opcode != PongMessage || string(payload) != "ping" {
+		t.Errorf("got opcode %d %q; want pong \"ping\"", opcode, payload)
+	}
+}
+
+func TestReadMessageRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		send   func(c *client)
+		want   error
+		status int
+	}{
+		{"unmasked frame", func(c *client) {
+			c.writeFrame(true, TextMessage, []byte("hello"), false)
+		}, ErrProtocol, CloseProtocolError},
+		{"continuation without a message", func(c *client) {
+			c.writeFrame(true, continuationFrame, []byte("hello"), true)
+		}, ErrProtocol, CloseProtocolError},
+		{"new message before the last finished", func(c *client) {
+			c.writeFrame(false, TextMessage, []byte("hel"), true)
+			c.writeFrame(true, TextMessage, []byte("lo"), true)
+		}, ErrProtocol, CloseProtocolError},
+		{"unknown opcode", func(c *client) {
+			c.writeFrame(true, 3, []byte("hello"), true)
+		}, ErrProtocol, CloseProtocolError},
+		{"fragmented control frame", func(c *client) {
+			c.writeFrame(false, PingMessage, []byte("ping"), true)
+		}, ErrProtocol, CloseProtocolError},
+		{"long control frame", func(c *client) {
+			c.writeFrame(true, PingMessage, bytes.Repeat([]byte("x"), 126), true)
+		}, ErrProtocol, CloseProtocolError},
+		{"oversized frame", func(c *client) {
+			// The frame is refused from its header, so only send that.
+			header := binary.BigEndian.AppendUint64([]byte{0x80 | TextMessage, 0x80 | 127}, maxMessageSize+1)
+			if _, err := c.conn.Write(header); err != nil {
+				c.t.Fatal(err)
+			}
+		}, ErrMessageTooLarge, CloseMessageTooBig},
+		{"oversized fragmented message", func(c *client) {
+			half := bytes.Repeat([]byte("x"), maxMessageSize/2+1)
+			c.writeFrame(false, TextMessage, half, true)
+			c.writeFrame(true, continuationFrame, half, true)
+		}, ErrMessageTooLarge, CloseMessageTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, c := dial(t)
+			tt.send(c)
+			_, _, err := conn.ReadMessage()
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v; want %v", err, tt.want)
+			}
+			c.expectClose(tt.status)
+		})
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	conn, c := dial(t)
+	c.writeFrame(true, CloseMessage, binary.BigEndian.AppendUint16(nil, CloseGoingAway), true)
+	_, _, err := conn.ReadMessage()
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("got error %v; want ErrClosed", err)
+	}
+	// The server echoes the client's status code.
+	c.expectClose(CloseGoingAway)
+
+	conn, c = dial(t)
+	c.writeFrame(true, CloseMessage, nil, true)
+	_, _, err = conn.ReadMessage()
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("got error %v; want ErrClosed", err)
+	}
+	_, opcode, payload := c.readFrame()
+	if opcode != CloseMessage || len(payload) != 0 {
+		t.Errorf("got opcode %d %v; want an empty close frame", opcode, payload)
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	conn, c := dial(t)
+	// Each size needs a different length encoding.
+	for _, size := range []int{0, 125, 126, 0xffff, 0x10000} {
+		payload := bytes.Repeat([]byte("x"), size)
+		errs := make(chan error, 1)
+		go func() { errs <- conn.WriteMessage(BinaryMessage, payload) }()
+		fin, opcode, got := c.readFrame()
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		if !fin || opcode != BinaryMessage || !bytes.Equal(got, payload) {
+			t.Errorf("size %d: got fin %t, opcode %d, %d bytes", size, fin, opcode, len(got))
+		}
+	}
+	if err := conn.WriteJSON(map[string]int{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+	_, opcode, payload := c.readFrame()
+	if opcode != TextMessage || string(payload) != `{"a":1}` {
+		t.Errorf("got opcode %d %q; want text {\"a\":1}", opcode, payload)
+	}
+}
@@ -0,0 +1,15 @@
+DROP INDEX IF EXISTS games_subject_idx;
+ALTER TABLE games DROP CONSTRAINT IF EXISTS games_difficulty_check;
+ALTER TABLE games DROP CONSTRAINT IF EXISTS games_play_time_check;
+ALTER TABLE games DROP CONSTRAINT IF EXISTS games_age_check;
+ALTER TABLE games DROP CONSTRAINT IF EXISTS games_players_check;
+ALTER TABLE games DROP COLUMN IF EXISTS year;
+ALTER TABLE games DROP COLUMN IF EXISTS publisher;
+ALTER TABLE games DROP COLUMN IF EXISTS difficulty;
+ALTER TABLE games DROP COLUMN IF EXISTS subject;
+ALTER TABLE games DROP COLUMN IF EXISTS play_time;
+ALTER TABLE games DROP COLUMN IF EXISTS max_age;
+ALTER TABLE games DROP COLUMN IF EXISTS min_age;
+ALTER TABLE games DROP COLUMN IF EXISTS max_players;
+ALTER TABLE games DROP COLUMN IF EXISTS min_players;
+ALTER TABLE games DROP COLUMN IF EXISTS description;
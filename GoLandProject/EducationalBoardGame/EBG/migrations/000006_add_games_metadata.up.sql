@@ -0,0 +1,15 @@
+ALTER TABLE games ADD COLUMN IF NOT EXISTS description text NOT NULL DEFAULT '';
+ALTER TABLE games ADD COLUMN IF NOT EXISTS min_players integer NOT NULL DEFAULT 0;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS max_players integer NOT NULL DEFAULT 0;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS min_age integer NOT NULL DEFAULT 0;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS max_age integer NOT NULL DEFAULT 0;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS play_time integer NOT NULL DEFAULT 0;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS subject text NOT NULL DEFAULT '';
+ALTER TABLE games ADD COLUMN IF NOT EXISTS difficulty text NOT NULL DEFAULT '';
+ALTER TABLE games ADD COLUMN IF NOT EXISTS publisher text NOT NULL DEFAULT '';
+ALTER TABLE games ADD COLUMN IF NOT EXISTS year integer NOT NULL DEFAULT 0;
+ALTER TABLE games ADD CONSTRAINT games_players_check CHECK (min_players >= 0 AND max_players >= min_players);
+ALTER TABLE games ADD CONSTRAINT games_age_check CHECK (min_age >= 0 AND (max_age = 0 OR max_age >= min_age));
+ALTER TABLE games ADD CONSTRAINT games_play_time_check CHECK (play_time >= 0);
+ALTER TABLE games ADD CONSTRAINT games_difficulty_check CHECK (difficulty IN ('', 'easy', 'medium', 'hard'));
+CREATE INDEX IF NOT EXISTS games_subject_idx ON games (lower(subject));
@@ -0,0 +1,2 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS games_title_trgm_idx ON games USING GIN (title gin_trgm_ops);
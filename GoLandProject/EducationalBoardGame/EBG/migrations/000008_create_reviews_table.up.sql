@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    game_id bigint NOT NULL REFERENCES games ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    rating integer NOT NULL,
+    body text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (game_id, user_id),
+    CONSTRAINT reviews_rating_check CHECK (rating BETWEEN 1 AND 5)
+    );
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
+INSERT INTO permissions (code)
+VALUES
+    ('reviews:moderate');
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS collections_games;
+DROP TABLE IF EXISTS collections;
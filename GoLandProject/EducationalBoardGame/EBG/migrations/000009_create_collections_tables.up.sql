@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS collections (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    share_token text UNIQUE,
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (user_id, name)
+    );
+CREATE TABLE IF NOT EXISTS collections_games (
+    collection_id bigint NOT NULL REFERENCES collections ON DELETE CASCADE,
+    game_id bigint NOT NULL REFERENCES games ON DELETE CASCADE,
+    position integer NOT NULL,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (collection_id, game_id)
+    );
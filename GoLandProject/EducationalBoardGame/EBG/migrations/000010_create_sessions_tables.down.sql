@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS sessions_players;
+DROP TABLE IF EXISTS sessions;
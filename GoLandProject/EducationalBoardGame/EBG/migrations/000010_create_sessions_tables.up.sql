@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    game_id bigint NOT NULL REFERENCES games ON DELETE CASCADE,
+    host_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    status text NOT NULL DEFAULT 'waiting',
+    turn integer NOT NULL DEFAULT 0,
+    state jsonb NOT NULL DEFAULT '{}',
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT sessions_status_check CHECK (status IN ('waiting', 'active', 'finished'))
+    );
+CREATE TABLE IF NOT EXISTS sessions_players (
+    session_id bigint NOT NULL REFERENCES sessions ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    seat integer NOT NULL,
+    joined_at timestamp(0) with time zone,
+    PRIMARY KEY (session_id, user_id),
+    UNIQUE (session_id, seat)
+    );
+CREATE INDEX IF NOT EXISTS sessions_players_user_id_idx ON sessions_players (user_id);
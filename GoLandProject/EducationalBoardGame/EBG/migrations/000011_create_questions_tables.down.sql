@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'questions:write';
+DROP TABLE IF EXISTS questions_games;
+DROP TABLE IF EXISTS questions;
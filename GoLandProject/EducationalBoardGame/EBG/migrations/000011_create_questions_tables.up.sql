@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS questions (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    kind text NOT NULL,
+    prompt text NOT NULL,
+    choices text[] NOT NULL DEFAULT '{}',
+    answers text[] NOT NULL,
+    tolerance double precision NOT NULL DEFAULT 0,
+    subject text NOT NULL DEFAULT '',
+    difficulty text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT questions_kind_check CHECK (kind IN ('multiple_choice', 'true_false', 'numeric', 'free_text')),
+    CONSTRAINT questions_difficulty_check CHECK (difficulty IN ('', 'easy', 'medium', 'hard')),
+    CONSTRAINT questions_tolerance_check CHECK (tolerance >= 0)
+    );
+CREATE TABLE IF NOT EXISTS questions_games (
+    question_id bigint NOT NULL REFERENCES questions ON DELETE CASCADE,
+    game_id bigint NOT NULL REFERENCES games ON DELETE CASCADE,
+    PRIMARY KEY (question_id, game_id)
+    );
+CREATE INDEX IF NOT EXISTS questions_games_game_id_idx ON questions_games (game_id);
+INSERT INTO permissions (code)
+VALUES
+    ('questions:write');
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'classrooms:write';
+DROP TABLE IF EXISTS classrooms_students;
+DROP TABLE IF EXISTS classrooms;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS classrooms (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    teacher_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    join_code text UNIQUE NOT NULL,
+    version integer NOT NULL DEFAULT 1
+    );
+CREATE INDEX IF NOT EXISTS classrooms_teacher_id_idx ON classrooms (teacher_id);
+CREATE TABLE IF NOT EXISTS classrooms_students (
+    classroom_id bigint NOT NULL REFERENCES classrooms ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    joined_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (classroom_id, user_id)
+    );
+CREATE INDEX IF NOT EXISTS classrooms_students_user_id_idx ON classrooms_students (user_id);
+INSERT INTO permissions (code)
+VALUES
+    ('classrooms:write');
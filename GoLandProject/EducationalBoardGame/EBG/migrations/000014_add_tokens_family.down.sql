@@ -0,0 +1,5 @@
+DELETE FROM tokens WHERE scope = 'refresh';
+DROP INDEX IF EXISTS tokens_family_idx;
+ALTER TABLE tokens DROP COLUMN IF EXISTS used_at;
+ALTER TABLE tokens DROP COLUMN IF EXISTS family;
+DROP SEQUENCE IF EXISTS tokens_family_seq;
@@ -0,0 +1,5 @@
+CREATE SEQUENCE IF NOT EXISTS tokens_family_seq;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family bigint;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used_at timestamp(0) with time zone;
+UPDATE tokens SET family = nextval('tokens_family_seq') WHERE scope = 'authentication' AND family IS NULL;
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family);
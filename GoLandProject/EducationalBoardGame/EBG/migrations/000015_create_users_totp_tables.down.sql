@@ -0,0 +1,3 @@
+DELETE FROM tokens WHERE scope = 'two-factor';
+DROP TABLE IF EXISTS users_recovery_codes;
+DROP TABLE IF EXISTS users_totp;
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'users:admin';
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_code_key;
@@ -0,0 +1,13 @@
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_code_key;
+INSERT INTO users_permissions (user_id, permission_id)
+SELECT users_permissions.user_id, keep.id
+FROM users_permissions
+INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+INNER JOIN (SELECT code, min(id) AS id FROM permissions GROUP BY code) keep ON keep.code = permissions.code
+WHERE permissions.id <> keep.id
+ON CONFLICT DO NOTHING;
+DELETE FROM permissions a USING permissions b WHERE a.code = b.code AND a.id > b.id;
+ALTER TABLE permissions ADD CONSTRAINT permissions_code_key UNIQUE (code);
+INSERT INTO permissions (code)
+VALUES
+    ('users:admin');
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    code text UNIQUE NOT NULL,
+    name text NOT NULL
+    );
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+    );
+CREATE TABLE IF NOT EXISTS users_roles (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+    );
+CREATE INDEX IF NOT EXISTS users_roles_role_id_idx ON users_roles (role_id);
+INSERT INTO roles (code, name)
+VALUES
+    ('student', 'Student'),
+    ('teacher', 'Teacher'),
+    ('school-admin', 'School administrator'),
+    ('superadmin', 'Superadmin');
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE (roles.code = 'student' AND permissions.code IN ('games:read'))
+   OR (roles.code = 'teacher' AND permissions.code IN ('games:read', 'questions:write', 'classrooms:write'))
+   OR (roles.code = 'school-admin' AND permissions.code IN ('games:read', 'games:write', 'questions:write', 'classrooms:write', 'reviews:moderate'))
+   OR roles.code = 'superadmin';
+INSERT INTO users_roles (user_id, role_id)
+SELECT users.id, roles.id
+FROM users, roles
+WHERE roles.code = 'student';
@@ -0,0 +1,2 @@
+DELETE FROM tokens WHERE scope = 'email-change';
+DROP TABLE IF EXISTS users_pending_emails;
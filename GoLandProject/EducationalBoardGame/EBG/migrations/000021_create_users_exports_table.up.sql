@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS users_exports (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    requested_by bigint REFERENCES users ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    format text NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    completed_at timestamp(0) with time zone,
+    expires_at timestamp(0) with time zone,
+    archive bytea
+    );
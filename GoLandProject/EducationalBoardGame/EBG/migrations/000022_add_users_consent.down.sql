@@ -0,0 +1,5 @@
+DELETE FROM tokens WHERE scope = 'consent';
+DROP TABLE IF EXISTS users_consent_events;
+ALTER TABLE users DROP COLUMN IF EXISTS consented_at;
+ALTER TABLE users DROP COLUMN IF EXISTS guardian_email;
+ALTER TABLE users DROP COLUMN IF EXISTS date_of_birth;
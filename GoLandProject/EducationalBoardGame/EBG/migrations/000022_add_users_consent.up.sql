@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS date_of_birth date;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS guardian_email citext NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS consented_at timestamp(0) with time zone;
+
+CREATE TABLE IF NOT EXISTS users_consent_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    event text NOT NULL,
+    guardian_email citext NOT NULL,
+    ip_address text NOT NULL DEFAULT '',
+    user_agent text NOT NULL DEFAULT ''
+    );
+CREATE INDEX IF NOT EXISTS users_consent_events_user_id_idx ON users_consent_events (user_id);
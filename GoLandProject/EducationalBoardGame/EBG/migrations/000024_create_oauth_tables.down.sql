@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS oauth_tokens;
+DROP SEQUENCE IF EXISTS oauth_tokens_family_seq;
+DROP TABLE IF EXISTS oauth_codes;
+DROP TABLE IF EXISTS oauth_clients;